@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	defaultACL = "world:anyone:cdrwa"
+)
+
+var (
+	aclPerms = map[rune]int32{
+		'c': zk.PermCreate,
+		'd': zk.PermDelete,
+		'r': zk.PermRead,
+		'w': zk.PermWrite,
+		'a': zk.PermAdmin,
+	}
+)
+
+// parseACL parses a comma separated list of scheme:id:perms entries like
+// auth::cdrwa,world:anyone:r. The id of the digest scheme is user:hash
+func parseACL(spec string) ([]zk.ACL, error) {
+	var acl []zk.ACL
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		first := strings.Index(entry, ":")
+		last := strings.LastIndex(entry, ":")
+		if first == -1 || first == last {
+			return nil, fmt.Errorf("invalid acl %q expected scheme:id:perms", entry)
+		}
+		scheme := entry[:first]
+		id := entry[first+1 : last]
+		perms, err := parseACLPerms(entry[last+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl %q: %v", entry, err.Error())
+		}
+		switch scheme {
+		case "world", "auth", "ip":
+		case "digest":
+			if !strings.Contains(id, ":") {
+				return nil, fmt.Errorf("invalid acl %q expected digest id user:hash", entry)
+			}
+		default:
+			return nil, fmt.Errorf("invalid acl %q unknown scheme %v", entry, scheme)
+		}
+		acl = append(acl, zk.ACL{Perms: perms, Scheme: scheme, ID: id})
+	}
+	if len(acl) == 0 {
+		return nil, fmt.Errorf("empty acl")
+	}
+	return acl, nil
+}
+
+func parseACLPerms(perms string) (int32, error) {
+	var result int32
+	for _, c := range perms {
+		perm, ok := aclPerms[c]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission %q", c)
+		}
+		result |= perm
+	}
+	if result == 0 {
+		return 0, fmt.Errorf("no permissions")
+	}
+	return result, nil
+}
+
+// aclNeedsAuth returns true if the acl uses the auth scheme, zookeeper
+// refuses it from unauthenticated clients
+func aclNeedsAuth(acl []zk.ACL) bool {
+	for _, entry := range acl {
+		if entry.Scheme == "auth" {
+			return true
+		}
+	}
+	return false
+}
+
+// readAuthFile reads user:password digest credentials from a file, like a
+// mounted kubernetes secret
+func readAuthFile(authFile string) ([]byte, error) {
+	data, err := ioutil.ReadFile(authFile)
+	if err != nil {
+		return nil, err
+	}
+	auth := strings.TrimSpace(string(data))
+	if !strings.Contains(auth, ":") {
+		return nil, fmt.Errorf("invalid credentials in %v expected user:password", authFile)
+	}
+	return []byte(auth), nil
+}
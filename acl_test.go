@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncParseACL(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		spec          string
+		expected      []zk.ACL
+		expectedError bool
+	}{
+
+		{
+			testName: "default acl",
+			spec:     defaultACL,
+			expected: zk.WorldACL(zk.PermAll),
+		},
+
+		{
+			testName: "creator all and world read",
+			spec:     "auth::cdrwa, world:anyone:r",
+			expected: []zk.ACL{
+				{Perms: zk.PermAll, Scheme: "auth", ID: ""},
+				{Perms: zk.PermRead, Scheme: "world", ID: "anyone"},
+			},
+		},
+
+		{
+			testName: "digest and ip",
+			spec:     "digest:finagle:hash=:r,ip:10.0.0.0/8:rw",
+			expected: []zk.ACL{
+				{Perms: zk.PermRead, Scheme: "digest", ID: "finagle:hash="},
+				{Perms: zk.PermRead | zk.PermWrite, Scheme: "ip", ID: "10.0.0.0/8"},
+			},
+		},
+
+		{
+			testName:      "digest without hash",
+			spec:          "digest:finagle:r",
+			expectedError: true,
+		},
+
+		{
+			testName:      "unknown scheme",
+			spec:          "sasl:finagle:r",
+			expectedError: true,
+		},
+
+		{
+			testName:      "unknown perm",
+			spec:          "world:anyone:rx",
+			expectedError: true,
+		},
+
+		{
+			testName:      "missing perms",
+			spec:          "world:anyone",
+			expectedError: true,
+		},
+
+		{
+			testName:      "empty",
+			spec:          "",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		acl, err := parseACL(tc.spec)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+		} else {
+			assert.Nil(t, err, tc.testName)
+			assert.Equal(t, tc.expected, acl, tc.testName)
+		}
+	}
+}
+
+func TestFuncReadAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "announser")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	authFile := filepath.Join(dir, "auth")
+	assert.Nil(t, ioutil.WriteFile(authFile, []byte("announser:secret\n"), 0600))
+	auth, err := readAuthFile(authFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("announser:secret"), auth)
+
+	assert.Nil(t, ioutil.WriteFile(authFile, []byte("secret"), 0600))
+	_, err = readAuthFile(authFile)
+	assert.NotNil(t, err)
+
+	_, err = readAuthFile(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
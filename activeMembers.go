@@ -2,30 +2,99 @@ package main
 
 func newActiveMembers() *activeMembers {
 	active := activeMembers{
-		data: make(map[string]string),
+		data: make(map[string]map[string]activeMember),
 	}
 	return &active
 }
 
+// activeMember is a member znode and the member data written to it
+type activeMember struct {
+	znode    string
+	member   *zkMember
+	modified bool // the znode data was modified by others
+}
+
+// activeMembers keeps track of the member znodes written for every service
+// keyed by service and member id
 type activeMembers struct {
-	data map[string]string
+	data map[string]map[string]activeMember
 }
 
-func (a *activeMembers) add(key, val string) {
-	a.data[key] = val
+func (a *activeMembers) add(key, id, val string, member *zkMember) {
+	if _, ok := a.data[key]; !ok {
+		a.data[key] = make(map[string]activeMember)
+	}
+	a.data[key][id] = activeMember{znode: val, member: member}
 }
 
-func (a *activeMembers) delete(key string) {
-	delete(a.data, key)
+func (a *activeMembers) delete(key, id string) {
+	members, ok := a.data[key]
+	if !ok {
+		return
+	}
+	delete(members, id)
+	if len(members) == 0 {
+		delete(a.data, key)
+	}
 }
 
-func (a *activeMembers) get(key string) string {
-	if val, ok := a.data[key]; ok {
-		return val
+func (a *activeMembers) get(key, id string) string {
+	if val, ok := a.data[key][id]; ok {
+		return val.znode
 	}
 	return ""
 }
 
+// getMember returns the member data last written for the member id
+func (a *activeMembers) getMember(key, id string) *zkMember {
+	if val, ok := a.data[key][id]; ok {
+		return val.member
+	}
+	return nil
+}
+
+// find returns the service key and member id of the znode
+func (a *activeMembers) find(znode string) (string, string, bool) {
+	for key, members := range a.data {
+		for id, val := range members {
+			if val.znode == znode {
+				return key, id, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// markModified marks the member id as modified in zookeeper, so the member
+// data is written again even if it did not change
+func (a *activeMembers) markModified(key, id string) {
+	if val, ok := a.data[key][id]; ok {
+		val.modified = true
+		a.data[key][id] = val
+	}
+}
+
+// isModified returns true if the member id was marked as modified
+func (a *activeMembers) isModified(key, id string) bool {
+	return a.data[key][id].modified
+}
+
+func (a *activeMembers) ids(key string) []string {
+	var ids []string
+	for id := range a.data[key] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (a *activeMembers) keys() []string {
+	var keys []string
+	for key := range a.data {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 func (a *activeMembers) keyIn(key string) bool {
 	if _, ok := a.data[key]; ok {
 		return true
@@ -8,14 +8,69 @@ import (
 
 func TestActiveMembers(t *testing.T) {
 	active := newActiveMembers()
-	active.add("1", "A")
+	member := newZKMember()
+	active.add("1", "a", "A", member)
+	active.add("1", "b", "B", nil)
 
-	assert.Equal(t, "A", active.get("1"))
+	assert.Equal(t, "A", active.get("1", "a"))
+	assert.Equal(t, "B", active.get("1", "b"))
+	assert.Equal(t, "", active.get("1", "c"))
+	assert.Equal(t, member, active.getMember("1", "a"))
+	assert.Nil(t, active.getMember("1", "c"))
+	assert.ElementsMatch(t, []string{"a", "b"}, active.ids("1"))
 
 	assert.True(t, active.keyIn("1"))
 	assert.False(t, active.keyIn("2"))
+	assert.Equal(t, []string{"1"}, active.keys())
 
-	active.delete("1")
+	active.delete("1", "a")
+	assert.True(t, active.keyIn("1"))
+	assert.Equal(t, []string{"b"}, active.ids("1"))
+
+	active.delete("1", "b")
 	assert.False(t, active.keyIn("1"))
 
 }
+
+func TestActiveMembersNamespaces(t *testing.T) {
+	active := newActiveMembers()
+	active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+	active.add("prod/api", "10.0.0.1", "/aurora/jobs/api/member_0000000002", nil)
+
+	assert.Equal(t, "/aurora/jobs/api/member_0000000001", active.get("dev/api", "10.0.0.1"))
+	assert.Equal(t, "/aurora/jobs/api/member_0000000002", active.get("prod/api", "10.0.0.1"))
+
+	active.delete("prod/api", "10.0.0.1")
+	assert.False(t, active.keyIn("prod/api"))
+	assert.True(t, active.keyIn("dev/api"))
+}
+
+func TestActiveMembersFind(t *testing.T) {
+	active := newActiveMembers()
+	active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+	active.add("prod/api", "10.0.0.2", "/aurora/jobs/api/member_0000000002", nil)
+
+	key, id, ok := active.find("/aurora/jobs/api/member_0000000002")
+	assert.True(t, ok)
+	assert.Equal(t, "prod/api", key)
+	assert.Equal(t, "10.0.0.2", id)
+
+	_, _, ok = active.find("/aurora/jobs/api/member_0000000003")
+	assert.False(t, ok)
+}
+
+func TestActiveMembersModified(t *testing.T) {
+	active := newActiveMembers()
+	active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+	assert.False(t, active.isModified("dev/api", "10.0.0.1"))
+
+	active.markModified("dev/api", "10.0.0.1")
+	assert.True(t, active.isModified("dev/api", "10.0.0.1"))
+
+	active.markModified("dev/api", "10.0.0.2")
+	assert.False(t, active.isModified("dev/api", "10.0.0.2"))
+	assert.Equal(t, []string{"10.0.0.1"}, active.ids("dev/api"))
+
+	active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+	assert.False(t, active.isModified("dev/api", "10.0.0.1"))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// announserConfig is the announser config file
+//
+//	aclPolicies:
+//	  payments:
+//	    acl: "auth::cdrwa,digest:payments:<hash>:r,ip:10.1.0.0/16:r"
+//	    parents: true
+//	paths:
+//	  deny: ["/aurora/jobs/infra"]
+//	  maxDepth: 8
+//	namespacePaths:
+//	  - namespaces: ["dev"]
+//	    prefixes: ["/aurora/jobs/dev"]
+//	pathConflicts: reject-newer
+//	ensembles:
+//	  us-east-prod:
+//	    connectString: "zk1.us-east:2181,zk2.us-east:2181"
+type announserConfig struct {
+	ACLPolicies    map[string]*aclPolicy      `json:"aclPolicies"`
+	Paths          pathPolicy                 `json:"paths"`
+	NamespacePaths []*namespacePathRule       `json:"namespacePaths"`
+	PathConflicts  string                     `json:"pathConflicts"` // allow, warn (default) or reject-newer
+	Ensembles      map[string]*ensembleConfig `json:"ensembles"`
+}
+
+// aclPolicy is a named acl services select with the acl policy annotation
+type aclPolicy struct {
+	ACL     string `json:"acl"`     // scheme:id:perms entries
+	Parents bool   `json:"parents"` // also used for the parent znodes created
+
+	acl []zk.ACL
+}
+
+func newAnnounserConfig() *announserConfig {
+	config := announserConfig{
+		ACLPolicies: make(map[string]*aclPolicy),
+	}
+	return &config
+}
+
+// loadConfig reads and validates the config file, an empty file name
+// returns the default config
+func loadConfig(configFile string) (*announserConfig, error) {
+	config := newAnnounserConfig()
+	if configFile == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	err = parseConfig(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %v: %v", configFile, err.Error())
+	}
+	return config, nil
+}
+
+func parseConfig(data []byte, config *announserConfig) error {
+	err := yaml.Unmarshal(data, config)
+	if err != nil {
+		return err
+	}
+	if config.ACLPolicies == nil {
+		config.ACLPolicies = make(map[string]*aclPolicy)
+	}
+	for name, policy := range config.ACLPolicies {
+		if policy == nil {
+			return fmt.Errorf("empty acl policy %v", name)
+		}
+		acl, err := parseACL(policy.ACL)
+		if err != nil {
+			return fmt.Errorf("acl policy %v: %v", name, err.Error())
+		}
+		policy.acl = acl
+	}
+	err = config.Paths.validate()
+	if err != nil {
+		return fmt.Errorf("paths: %v", err.Error())
+	}
+	err = validConflictPolicy(config.PathConflicts)
+	if err != nil {
+		return fmt.Errorf("pathConflicts: %v", err.Error())
+	}
+	for name, ensemble := range config.Ensembles {
+		if ensemble == nil {
+			return fmt.Errorf("empty ensemble %v", name)
+		}
+		if name == defaultEnsemble {
+			return fmt.Errorf("ensemble %v is the ensemble of the flags", name)
+		}
+		err = ensemble.validate()
+		if err != nil {
+			return fmt.Errorf("ensemble %v: %v", name, err.Error())
+		}
+	}
+	for i, rule := range config.NamespacePaths {
+		if rule == nil {
+			return fmt.Errorf("namespacePaths: empty rule %v", i)
+		}
+		err = rule.validate()
+		if err != nil {
+			return fmt.Errorf("namespacePaths: rule %v: %v", i, err.Error())
+		}
+	}
+	return nil
+}
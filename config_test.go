@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncParseConfig(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		data          string
+		policies      map[string]*aclPolicy
+		expectedError bool
+	}{
+
+		{
+			testName: "empty config",
+			data:     "",
+			policies: map[string]*aclPolicy{},
+		},
+
+		{
+			testName: "with acl policies",
+			data: `
+aclPolicies:
+  payments:
+    acl: "auth::cdrwa,ip:10.1.0.0/16:r"
+    parents: true
+  public:
+    acl: "world:anyone:r"
+`,
+			policies: map[string]*aclPolicy{
+				"payments": {
+					ACL:     "auth::cdrwa,ip:10.1.0.0/16:r",
+					Parents: true,
+					acl: []zk.ACL{
+						{Perms: zk.PermAll, Scheme: "auth", ID: ""},
+						{Perms: zk.PermRead, Scheme: "ip", ID: "10.1.0.0/16"},
+					},
+				},
+				"public": {
+					ACL: "world:anyone:r",
+					acl: zk.WorldACL(zk.PermRead),
+				},
+			},
+		},
+
+		{
+			testName: "with invalid acl",
+			data: `
+aclPolicies:
+  payments:
+    acl: "nobody:r"
+`,
+			expectedError: true,
+		},
+
+		{
+			testName: "with invalid denied path",
+			data: `
+paths:
+  deny: ["aurora/jobs"]
+`,
+			expectedError: true,
+		},
+
+		{
+			testName: "with default ensemble",
+			data: `
+ensembles:
+  default:
+    connectString: "zk:2181"
+`,
+			expectedError: true,
+		},
+
+		{
+			testName: "with invalid ensemble",
+			data: `
+ensembles:
+  us-east-prod:
+    connectString: "/chroot"
+`,
+			expectedError: true,
+		},
+
+		{
+			testName: "with empty policy",
+			data: `
+aclPolicies:
+  payments:
+`,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		config := newAnnounserConfig()
+		err := parseConfig([]byte(tc.data), config)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+			continue
+		}
+		assert.Nil(t, err, tc.testName)
+		assert.Equal(t, tc.policies, config.ACLPolicies, tc.testName)
+	}
+}
+
+func TestParseConfigPaths(t *testing.T) {
+	config := newAnnounserConfig()
+	err := parseConfig([]byte(`
+paths:
+  deny: ["/aurora/jobs/infra/"]
+  maxDepth: 8
+`), config)
+	assert.Nil(t, err)
+	assert.Equal(t, pathPolicy{Deny: []string{"/aurora/jobs/infra"}, MaxDepth: 8}, config.Paths)
+}
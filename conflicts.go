@@ -0,0 +1,223 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// policies for services announsing into the same path
+const (
+	conflictPolicyAllow       = "allow"        // merge the members silently
+	conflictPolicyWarn        = "warn"         // merge the members and report the conflict
+	conflictPolicyRejectNewer = "reject-newer" // only announse the oldest service
+)
+
+// serviceAnnotationConflict reports the path conflict of a service, the
+// service status has no conditions to report it in
+const serviceAnnotationConflict = "service.announser/path-conflict"
+
+// servicePathIndex indexes the services by their normalized path annotation
+const servicePathIndex = "path"
+
+// pathConflicts is the number of services announsing into a path used by
+// other services
+var pathConflicts = expvar.NewInt("path_conflicts")
+
+func validConflictPolicy(policy string) error {
+	switch policy {
+	case "", conflictPolicyAllow, conflictPolicyWarn, conflictPolicyRejectNewer:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q, use %v, %v or %v", policy, conflictPolicyAllow, conflictPolicyWarn, conflictPolicyRejectNewer)
+}
+
+// servicePathIndexFunc returns the normalized path annotation of the service,
+// the path policies are checked after the lookup
+func servicePathIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return nil, nil
+	}
+	annotation, ok := service.GetAnnotations()[serviceAnnotationPath]
+	if !ok {
+		return nil, nil
+	}
+	memberPath, err := normalizePath(annotation)
+	if err != nil {
+		return nil, nil
+	}
+	return []string{memberPath}, nil
+}
+
+// isOlderService returns true if service was created before other, services
+// created in the same second are ordered by key
+func isOlderService(service, other *v1.Service) bool {
+	created, otherCreated := service.GetCreationTimestamp(), other.GetCreationTimestamp()
+	if !created.Equal(&otherCreated) {
+		return created.Before(&otherCreated)
+	}
+	key, _ := cache.MetaNamespaceKeyFunc(service)
+	otherKey, _ := cache.MetaNamespaceKeyFunc(other)
+	return key < otherKey
+}
+
+// announsedPath returns the normalized path the service is announsed into,
+// empty if the service is not announsed
+func (c *serviceController) announsedPath(service *v1.Service) string {
+	if service.GetDeletionTimestamp() != nil || checkRequiredServiceFieldsExists(service) != nil {
+		return ""
+	}
+	memberPath, err := c.config.Paths.check(service.GetAnnotations()[serviceAnnotationPath])
+	if err != nil {
+		return ""
+	}
+	err = checkNamespacePath(c.config.NamespacePaths, service.GetNamespace(), c.getNamespaceLabels(service.GetNamespace()), memberPath)
+	if err != nil {
+		return ""
+	}
+	return memberPath
+}
+
+// findPathServices returns the services other than key announsed into the path
+func (c *serviceController) findPathServices(key, memberPath string) []*v1.Service {
+	objs, err := c.indexer.ByIndex(servicePathIndex, memberPath)
+	if err != nil {
+		log.Debugf("failed to look up services of path %v: %v", memberPath, err.Error())
+		return nil
+	}
+	var found []*v1.Service
+	for _, obj := range objs {
+		service, ok := obj.(*v1.Service)
+		if !ok {
+			continue
+		}
+		if serviceKey, err := cache.MetaNamespaceKeyFunc(service); err != nil || serviceKey == key {
+			continue
+		}
+		if c.announsedPath(service) == memberPath {
+			found = append(found, service)
+		}
+	}
+	return found
+}
+
+// checkPathConflict returns the conflict of the service with other services
+// announsed into the same path of an ensemble, and an error when the policy
+// rejects the service
+func (c *serviceController) checkPathConflict(key string, service *v1.Service, memberPath string) (string, error) {
+	if c.config.PathConflicts == conflictPolicyAllow {
+		return "", nil
+	}
+	ensembles := getServiceEnsembles(service)
+	var keys []string
+	var older []string
+	for _, other := range c.findPathServices(key, memberPath) {
+		if !sharesEnsemble(ensembles, getServiceEnsembles(other)) {
+			continue
+		}
+		otherKey, _ := cache.MetaNamespaceKeyFunc(other)
+		keys = append(keys, otherKey)
+		if isOlderService(other, service) {
+			older = append(older, otherKey)
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	sort.Strings(keys)
+	sort.Strings(older)
+	conflict := fmt.Sprintf("path %v is also announsed into by %v", memberPath, strings.Join(keys, ", "))
+	if c.config.PathConflicts == conflictPolicyRejectNewer && len(older) != 0 {
+		return conflict, fmt.Errorf("path %v is already announsed into by the older service %v", memberPath, strings.Join(older, ", "))
+	}
+	return conflict, nil
+}
+
+// setConflict records the path conflict of the service, an empty conflict
+// when the service has none
+func (c *serviceController) setConflict(key, conflict string) {
+	c.conflictsMu.Lock()
+	defer c.conflictsMu.Unlock()
+	if c.conflicts == nil {
+		c.conflicts = make(map[string]string)
+	}
+	if c.conflicts[key] == conflict {
+		return
+	}
+	if conflict != "" {
+		log.Warnf("service %v path conflict: %v", key, conflict)
+		c.conflicts[key] = conflict
+	} else {
+		log.Infof("service %v path conflict resolved", key)
+		delete(c.conflicts, key)
+	}
+	pathConflicts.Set(int64(len(c.conflicts)))
+}
+
+// getConflict returns the recorded path conflict of the service
+func (c *serviceController) getConflict(key string) string {
+	c.conflictsMu.Lock()
+	defer c.conflictsMu.Unlock()
+	return c.conflicts[key]
+}
+
+// updateConflictAnnotation reports the recorded path conflict of the service
+// in the conflict annotation
+func (c *serviceController) updateConflictAnnotation(key string) error {
+	service, err := c.getCachedService(key)
+	if err != nil || service == nil || service.GetDeletionTimestamp() != nil {
+		return err
+	}
+	conflict := c.getConflict(key)
+	if service.GetAnnotations()[serviceAnnotationConflict] == conflict {
+		return nil
+	}
+	service = service.DeepCopy()
+	annotations := service.GetAnnotations()
+	if conflict != "" {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[serviceAnnotationConflict] = conflict
+	} else {
+		delete(annotations, serviceAnnotationConflict)
+	}
+	service.SetAnnotations(annotations)
+	_, err = c.client.Core().Services(service.GetNamespace()).Update(service)
+	return err
+}
+
+// enqueuePathServices adds the services announsed into the path of the
+// service to the queue, their conflicts change with the service
+func (c *serviceController) enqueuePathServices(obj interface{}) {
+	if c.config.PathConflicts == conflictPolicyAllow {
+		return
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(service)
+	if err != nil {
+		return
+	}
+	memberPath := c.announsedPath(service)
+	if memberPath == "" {
+		return
+	}
+	for _, other := range c.findPathServices(key, memberPath) {
+		if otherKey, err := cache.MetaNamespaceKeyFunc(other); err == nil {
+			c.queue.Add(otherKey)
+		}
+	}
+}
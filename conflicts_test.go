@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOlderService(t *testing.T) {
+	created := time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC)
+	older := newTestService("dev", "api", "uid-1", "10.0.0.1", nil)
+	older.SetCreationTimestamp(metav1.NewTime(created))
+	newer := newTestService("dev", "web", "uid-2", "10.0.0.2", nil)
+	newer.SetCreationTimestamp(metav1.NewTime(created.Add(time.Minute)))
+	sameTime := newTestService("dev", "abc", "uid-3", "10.0.0.3", nil)
+	sameTime.SetCreationTimestamp(metav1.NewTime(created))
+
+	assert.True(t, isOlderService(older, newer))
+	assert.False(t, isOlderService(newer, older))
+	assert.True(t, isOlderService(sameTime, older))
+	assert.False(t, isOlderService(older, sameTime))
+}
+
+func TestValidConflictPolicy(t *testing.T) {
+	for _, policy := range []string{"", conflictPolicyAllow, conflictPolicyWarn, conflictPolicyRejectNewer} {
+		assert.Nil(t, validConflictPolicy(policy), policy)
+	}
+	assert.NotNil(t, validConflictPolicy("reject"))
+}
+
+func TestFindPathServices(t *testing.T) {
+	newAnnotations := func(memberPath string) map[string]string {
+		return map[string]string{
+			serviceAnnotationPath:     memberPath,
+			serviceAnnotationPortName: "http",
+		}
+	}
+	c := newTestController(
+		newTestService("dev", "api", "uid-1", "10.0.0.1", newAnnotations("/aurora/jobs/api")),
+		newTestService("prod", "api", "uid-2", "10.0.0.2", newAnnotations("/aurora//jobs/api/")),
+		newTestService("test", "api", "uid-3", "10.0.0.3", newAnnotations("/aurora/jobs/api-v2")),
+		newTestService("test", "web", "uid-4", "10.0.0.4", nil),
+		newTestService("test", "bad", "uid-5", "10.0.0.5", newAnnotations("aurora/../jobs")),
+	)
+
+	paths, err := servicePathIndexFunc(newTestService("dev", "api", "uid-1", "10.0.0.1", newAnnotations("/aurora//jobs/api/")))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/aurora/jobs/api"}, paths)
+
+	var keys []string
+	for _, service := range c.findPathServices("dev/api", "/aurora/jobs/api") {
+		keys = append(keys, service.GetNamespace()+"/"+service.GetName())
+	}
+	assert.Equal(t, []string{"prod/api"}, keys)
+	assert.Len(t, c.findPathServices("dev/api", "/aurora/jobs"), 0)
+}
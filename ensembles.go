@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+// defaultEnsemble is the ensemble of the zookeeper flags, used by services
+// without the ensembles annotation
+const defaultEnsemble = "default"
+
+// ensembleConfig is a named zookeeper ensemble services select with the
+// ensembles annotation. The acl and member options are taken from the flags
+//
+//	ensembles:
+//	  us-east-prod:
+//	    connectString: "zk1.us-east:2181,zk2.us-east:2181/announser"
+//	    authFile: /etc/announser/us-east-prod
+type ensembleConfig struct {
+	ConnectString string `json:"connectString"` // host1:port,host2:port[/chroot]
+	Exhibitor     string `json:"exhibitor"`     // discover the servers with the exhibitor rest api
+	SRVDomain     string `json:"srvDomain"`     // discover the servers with _zookeeper._tcp SRV records
+	AuthFile      string `json:"authFile"`      // user:password digest credentials, the flag when empty
+}
+
+func (e *ensembleConfig) validate() error {
+	if _, _, err := parseConnectString(e.ConnectString); err != nil {
+		return err
+	}
+	if e.Exhibitor != "" && e.SRVDomain != "" {
+		return fmt.Errorf("exhibitor and srvDomain can not be used together")
+	}
+	return nil
+}
+
+// zooConfig returns the zookeeper config of the ensemble based on the
+// config of the default ensemble
+func (e *ensembleConfig) zooConfig(defaults zooConfig) zooConfig {
+	config := defaults
+	config.connectString = e.ConnectString
+	config.exhibitorURL = e.Exhibitor
+	config.srvDomain = e.SRVDomain
+	if e.AuthFile != "" {
+		config.authFile = e.AuthFile
+	}
+	return config
+}
+
+// getServiceEnsembles returns the sorted ensembles selected by the ensembles
+// annotation of the service, the default ensemble without the annotation
+func getServiceEnsembles(service *v1.Service) []string {
+	annotation, ok := service.GetAnnotations()[serviceAnnotationEnsembles]
+	if !ok {
+		return []string{defaultEnsemble}
+	}
+	seen := make(map[string]bool)
+	var ensembles []string
+	for _, name := range strings.Split(annotation, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		ensembles = append(ensembles, name)
+	}
+	sort.Strings(ensembles)
+	return ensembles
+}
+
+// sharesEnsemble returns true if both lists have an ensemble in common
+func sharesEnsemble(ensembles, others []string) bool {
+	for _, name := range ensembles {
+		for _, other := range others {
+			if name == other {
+				return true
+			}
+		}
+	}
+	return false
+}
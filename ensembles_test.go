@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetServiceEnsembles(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		annotations map[string]string
+		expected    []string
+	}{
+		{testName: "without annotation", expected: []string{defaultEnsemble}},
+		{
+			testName:    "one ensemble",
+			annotations: map[string]string{serviceAnnotationEnsembles: "us-east-prod"},
+			expected:    []string{"us-east-prod"},
+		},
+		{
+			testName:    "sorted ensembles without duplicates",
+			annotations: map[string]string{serviceAnnotationEnsembles: "us-east-prod, eu-prod,us-east-prod,"},
+			expected:    []string{"eu-prod", "us-east-prod"},
+		},
+		{
+			testName:    "empty annotation",
+			annotations: map[string]string{serviceAnnotationEnsembles: ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		service := newTestService("dev", "api", "uid-1", "10.0.0.1", tc.annotations)
+		assert.Equal(t, tc.expected, getServiceEnsembles(service), tc.testName)
+	}
+}
+
+func TestEnsembleZooConfig(t *testing.T) {
+	defaults := zooConfig{
+		connectString: "zk:2181",
+		exhibitorURL:  "http://exhibitor:8080",
+		authFile:      "/etc/announser/auth",
+		persistent:    true,
+	}
+
+	ensemble := ensembleConfig{ConnectString: "zk1.us-east:2181/announser"}
+	assert.Nil(t, ensemble.validate())
+	config := ensemble.zooConfig(defaults)
+	assert.Equal(t, "zk1.us-east:2181/announser", config.connectString)
+	assert.Equal(t, "", config.exhibitorURL)
+	assert.Equal(t, "/etc/announser/auth", config.authFile)
+	assert.True(t, config.persistent)
+
+	ensemble = ensembleConfig{ConnectString: "zk1.eu:2181", AuthFile: "/etc/announser/eu"}
+	assert.Equal(t, "/etc/announser/eu", ensemble.zooConfig(defaults).authFile)
+
+	ensemble = ensembleConfig{}
+	assert.NotNil(t, ensemble.validate())
+	ensemble = ensembleConfig{ConnectString: "zk:2181", Exhibitor: "http://exhibitor:8080", SRVDomain: "example.com"}
+	assert.NotNil(t, ensemble.validate())
+}
+
+func TestUpdaterEnsembles(t *testing.T) {
+	u := newUpdater(zooConfig{connectString: "zk:2181"}, map[string]*ensembleConfig{
+		"us-east-prod": {ConnectString: "zk1.us-east:2181"},
+		"eu-prod":      {ConnectString: "zk1.eu:2181"},
+	})
+	assert.Equal(t, []string{defaultEnsemble, "eu-prod", "us-east-prod"}, u.ensembles())
+	assert.Equal(t, "zk1.eu:2181", u.zookeepers["eu-prod"].config.connectString)
+
+	u.zookeepers["eu-prod"].active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+	u.zookeepers["us-east-prod"].active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+	u.zookeepers[defaultEnsemble].active.add("dev/web", "10.0.0.2", "/aurora/jobs/web/member_0000000001", nil)
+	active := u.ActiveServices()
+	sort.Strings(active)
+	assert.Equal(t, []string{"dev/api", "dev/web"}, active)
+
+	event := UpdaterEvent{eventType: eventUpdate, name: "dev/api", ensembles: []string{"eu-prod"}}
+	assert.True(t, event.selects("eu-prod"))
+	assert.False(t, event.selects("us-east-prod"))
+	event.eventType = eventDelete
+	assert.False(t, event.selects("eu-prod"))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventSourceComponent = "k8s-zk-announser"
+	eventReasonTampered  = "MemberTampered"
+	eventReasonRejected  = "AnnounceRejected"
+)
+
+// newServiceEvent returns a kubernetes event about the service
+func newServiceEvent(service *v1.Service, eventType, reason, message string) *v1.Event {
+	now := metav1.Now()
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: service.GetName() + ".",
+			Namespace:    service.GetNamespace(),
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Service",
+			APIVersion:      "v1",
+			Namespace:       service.GetNamespace(),
+			Name:            service.GetName(),
+			UID:             service.GetUID(),
+			ResourceVersion: service.GetResourceVersion(),
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+}
+
+// recordEvent creates a kubernetes event about the service of the key
+func (c *serviceController) recordEvent(key, eventType, reason, message string) {
+	service, err := c.getCachedService(key)
+	if err != nil || service == nil {
+		return
+	}
+	event := newServiceEvent(service, eventType, reason, message)
+	_, err = c.client.Core().Events(service.GetNamespace()).Create(event)
+	if err != nil {
+		log.Errorf("failed to record event for service %v: %v", key, err.Error())
+	}
+}
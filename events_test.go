@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewServiceEvent(t *testing.T) {
+	service := newTestService("dev", "api", "uid-1", "10.0.0.1", nil)
+	event := newServiceEvent(service, v1.EventTypeWarning, eventReasonTampered, "member deleted")
+
+	assert.Equal(t, "dev", event.GetNamespace())
+	assert.Equal(t, "api.", event.GetGenerateName())
+	assert.Equal(t, "Service", event.InvolvedObject.Kind)
+	assert.Equal(t, "api", event.InvolvedObject.Name)
+	assert.Equal(t, "uid-1", string(event.InvolvedObject.UID))
+	assert.Equal(t, v1.EventTypeWarning, event.Type)
+	assert.Equal(t, eventReasonTampered, event.Reason)
+	assert.Equal(t, "member deleted", event.Message)
+}
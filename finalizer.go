@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceFinalizer keeps a service with persistent members until the
+// announser deleted them
+const serviceFinalizer = "service.announser/members"
+
+// serviceAnnotationMemberPaths records the paths of the persistent members
+// next to the finalizer, so the members are found again when the path
+// annotation changed while the announser was down
+const serviceAnnotationMemberPaths = "service.announser/member-paths"
+
+func hasFinalizer(service *v1.Service, finalizer string) bool {
+	for _, f := range service.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutFinalizer returns the finalizers without finalizer
+func withoutFinalizer(finalizers []string, finalizer string) []string {
+	var result []string
+	for _, f := range finalizers {
+		if f != finalizer {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// getMemberPaths returns the recorded paths of the persistent members of the service
+func getMemberPaths(service *v1.Service) []string {
+	var paths []string
+	for _, memberPath := range strings.Split(service.GetAnnotations()[serviceAnnotationMemberPaths], ",") {
+		if memberPath = strings.TrimSpace(memberPath); memberPath != "" {
+			paths = append(paths, memberPath)
+		}
+	}
+	return paths
+}
+
+// withMemberPath returns the sorted paths including memberPath, unless it is empty
+func withMemberPath(paths []string, memberPath string) []string {
+	var result []string
+	if memberPath != "" {
+		result = append(result, memberPath)
+	}
+	for _, p := range paths {
+		if p != memberPath {
+			result = append(result, p)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// setMemberPaths records the paths of the persistent members on the service
+func setMemberPaths(service *v1.Service, paths []string) {
+	annotations := make(map[string]string)
+	for k, v := range service.GetAnnotations() {
+		annotations[k] = v
+	}
+	if len(paths) == 0 {
+		delete(annotations, serviceAnnotationMemberPaths)
+	} else {
+		annotations[serviceAnnotationMemberPaths] = strings.Join(paths, ",")
+	}
+	service.SetAnnotations(annotations)
+}
+
+// getCachedService returns the service of the key from the cache, nil if
+// the service is gone
+func (c *serviceController) getCachedService(key string) (*v1.Service, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, err
+	}
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	return service, err
+}
+
+// addFinalizer adds the finalizer to the service and records the path
+// before its persistent members are written
+func (c *serviceController) addFinalizer(key, memberPath string) error {
+	service, err := c.getCachedService(key)
+	if err != nil || service == nil {
+		return err
+	}
+	paths := withMemberPath(getMemberPaths(service), memberPath)
+	if hasFinalizer(service, serviceFinalizer) && reflect.DeepEqual(paths, getMemberPaths(service)) {
+		return nil
+	}
+	service = service.DeepCopy()
+	if !hasFinalizer(service, serviceFinalizer) {
+		service.SetFinalizers(append(service.GetFinalizers(), serviceFinalizer))
+	}
+	setMemberPaths(service, paths)
+	_, err = c.client.Core().Services(service.GetNamespace()).Update(service)
+	if err != nil {
+		return err
+	}
+	log.Infof("added finalizer to service %v with member paths %v", key, strings.Join(paths, ","))
+	return nil
+}
+
+// pruneMemberPaths records memberPath as the only path of the persistent
+// members, once the members in the other recorded paths are deleted
+func (c *serviceController) pruneMemberPaths(key, memberPath string) error {
+	service, err := c.getCachedService(key)
+	if err != nil || service == nil || reflect.DeepEqual(getMemberPaths(service), []string{memberPath}) {
+		return err
+	}
+	service = service.DeepCopy()
+	setMemberPaths(service, []string{memberPath})
+	_, err = c.client.Core().Services(service.GetNamespace()).Update(service)
+	if err != nil {
+		return err
+	}
+	log.Infof("pruned member paths of service %v to %v", key, memberPath)
+	return nil
+}
+
+// removeFinalizer removes the finalizer and the recorded paths from the
+// service once its members are deleted, the service is then deleted or no
+// longer announsed
+func (c *serviceController) removeFinalizer(key string) error {
+	service, err := c.getCachedService(key)
+	if err != nil || service == nil {
+		return err
+	}
+	if !hasFinalizer(service, serviceFinalizer) && len(getMemberPaths(service)) == 0 {
+		return nil
+	}
+	service = service.DeepCopy()
+	service.SetFinalizers(withoutFinalizer(service.GetFinalizers(), serviceFinalizer))
+	setMemberPaths(service, nil)
+	_, err = c.client.Core().Services(service.GetNamespace()).Update(service)
+	if err != nil {
+		return err
+	}
+	log.Infof("removed finalizer from service %v", key)
+	return nil
+}
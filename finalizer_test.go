@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinalizers(t *testing.T) {
+	service := newTestService("dev", "api", "uid-1", "10.0.0.1", nil)
+	assert.False(t, hasFinalizer(service, serviceFinalizer))
+
+	service.SetFinalizers([]string{"foo", serviceFinalizer})
+	assert.True(t, hasFinalizer(service, serviceFinalizer))
+
+	assert.Equal(t, []string{"foo"}, withoutFinalizer(service.GetFinalizers(), serviceFinalizer))
+	assert.Nil(t, withoutFinalizer([]string{serviceFinalizer}, serviceFinalizer))
+}
+
+func TestMemberPaths(t *testing.T) {
+	service := newTestService("dev", "api", "uid-1", "10.0.0.1", nil)
+	assert.Nil(t, getMemberPaths(service))
+
+	setMemberPaths(service, withMemberPath(getMemberPaths(service), "/aurora/jobs/api"))
+	assert.Equal(t, "/aurora/jobs/api", service.GetAnnotations()[serviceAnnotationMemberPaths])
+
+	setMemberPaths(service, withMemberPath(getMemberPaths(service), "/aurora/jobs/admin"))
+	assert.Equal(t, []string{"/aurora/jobs/admin", "/aurora/jobs/api"}, getMemberPaths(service))
+	assert.Equal(t, []string{"/aurora/jobs/admin", "/aurora/jobs/api"}, withMemberPath(getMemberPaths(service), "/aurora/jobs/api"))
+	assert.Equal(t, []string{"/aurora/jobs/admin", "/aurora/jobs/api"}, withMemberPath(getMemberPaths(service), ""))
+
+	setMemberPaths(service, nil)
+	_, ok := service.GetAnnotations()[serviceAnnotationMemberPaths]
+	assert.False(t, ok)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serverLister returns the current host:port servers of the ensemble
+type serverLister func() ([]string, error)
+
+// pollingHostProvider is a zk.HostProvider that polls a serverLister and
+// replaces the servers when the ensemble changes
+type pollingHostProvider struct {
+	mu       sync.Mutex
+	servers  []string
+	curr     int
+	last     int
+	list     serverLister
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newPollingHostProvider(list serverLister, interval time.Duration) *pollingHostProvider {
+	hp := pollingHostProvider{
+		list:     list,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	return &hp
+}
+
+// Init lists the servers, the servers from the connect string are used
+// until the first successful list
+func (hp *pollingHostProvider) Init(servers []string) error {
+	if hp.interval <= 0 {
+		return fmt.Errorf("invalid zookeeper discovery interval %v", hp.interval)
+	}
+	found, err := hp.list()
+	if err != nil {
+		log.Errorf("failed to list zookeeper servers, using %v: %v", servers, err.Error())
+		found = servers
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no zookeeper servers found")
+	}
+	hp.setServers(found)
+	go hp.poll()
+	return nil
+}
+
+// poll refreshes the servers every interval until stopped
+func (hp *pollingHostProvider) poll() {
+	ticker := time.NewTicker(hp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			hp.refresh()
+		case <-hp.stop:
+			return
+		}
+	}
+}
+
+// Stop stops polling the servers
+func (hp *pollingHostProvider) Stop() {
+	hp.stopOnce.Do(func() { close(hp.stop) })
+}
+
+// refresh lists the servers and replaces them when they changed
+func (hp *pollingHostProvider) refresh() {
+	found, err := hp.list()
+	if err != nil {
+		log.Errorf("failed to list zookeeper servers: %v", err.Error())
+		return
+	}
+	if len(found) == 0 {
+		log.Warn("no zookeeper servers listed, keeping the current servers")
+		return
+	}
+	hp.setServers(found)
+}
+
+func (hp *pollingHostProvider) setServers(servers []string) {
+	sorted := append([]string{}, servers...)
+	sort.Strings(sorted)
+
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if reflect.DeepEqual(sorted, hp.servers) {
+		return
+	}
+	log.Infof("zookeeper servers: %v", strings.Join(sorted, ","))
+	hp.servers = sorted
+	hp.curr = -1
+	hp.last = -1
+}
+
+// Len returns the number of servers
+func (hp *pollingHostProvider) Len() int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return len(hp.servers)
+}
+
+// Next returns the next server to connect to. retryStart is true once all
+// servers were tried without Connected being called
+func (hp *pollingHostProvider) Next() (string, bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.curr = (hp.curr + 1) % len(hp.servers)
+	retryStart := hp.curr == hp.last
+	if hp.last == -1 {
+		hp.last = 0
+	}
+	return hp.servers[hp.curr], retryStart
+}
+
+// Connected notifies the provider of a successful connection
+func (hp *pollingHostProvider) Connected() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.last = hp.curr
+}
+
+// exhibitorClusterList is the response of the exhibitor cluster list endpoint
+type exhibitorClusterList struct {
+	Servers []string `json:"servers"`
+	Port    int      `json:"port"`
+}
+
+// newExhibitorLister returns a serverLister using the exhibitor rest api
+// at url, e.g. http://exhibitor:8080
+func newExhibitorLister(url string, client *http.Client) serverLister {
+	listURL := strings.TrimSuffix(url, "/") + "/exhibitor/v1/cluster/list"
+	return func() ([]string, error) {
+		resp, err := client.Get(listURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("exhibitor cluster list returned %v", resp.Status)
+		}
+
+		var list exhibitorClusterList
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exhibitor cluster list: %v", err.Error())
+		}
+		var servers []string
+		for _, server := range list.Servers {
+			servers = append(servers, net.JoinHostPort(server, strconv.Itoa(list.Port)))
+		}
+		return servers, nil
+	}
+}
+
+// srvResolver looks up SRV records, net.LookupSRV
+type srvResolver func(service, proto, name string) (string, []*net.SRV, error)
+
+// newSRVLister returns a serverLister resolving the _zookeeper._tcp SRV
+// records of domain
+func newSRVLister(domain string, resolve srvResolver) serverLister {
+	return func() ([]string, error) {
+		_, records, err := resolve("zookeeper", "tcp", domain)
+		if err != nil {
+			return nil, err
+		}
+		var servers []string
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			servers = append(servers, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+		return servers, nil
+	}
+}
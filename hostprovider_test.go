@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollingHostProvider(t *testing.T) {
+	servers := []string{"zk2:2181", "zk1:2181"}
+	hp := newPollingHostProvider(func() ([]string, error) {
+		return servers, nil
+	}, time.Hour)
+
+	assert.Nil(t, hp.Init([]string{"localhost:2181"}))
+	assert.Equal(t, 2, hp.Len())
+
+	server, retryStart := hp.Next()
+	assert.Equal(t, "zk1:2181", server)
+	assert.False(t, retryStart)
+	hp.Connected()
+	server, retryStart = hp.Next()
+	assert.Equal(t, "zk2:2181", server)
+	assert.False(t, retryStart)
+	server, retryStart = hp.Next()
+	assert.Equal(t, "zk1:2181", server)
+	assert.True(t, retryStart)
+
+	servers = []string{"zk1:2181", "zk2:2181", "zk3:2181"}
+	hp.refresh()
+	assert.Equal(t, 3, hp.Len())
+
+	// keep the current servers when the ensemble can not be listed
+	servers = nil
+	hp.refresh()
+	assert.Equal(t, 3, hp.Len())
+}
+
+func TestPollingHostProviderFallback(t *testing.T) {
+	hp := newPollingHostProvider(func() ([]string, error) {
+		return nil, fmt.Errorf("unavailable")
+	}, time.Hour)
+
+	assert.Nil(t, hp.Init([]string{"localhost:2181"}))
+	server, _ := hp.Next()
+	assert.Equal(t, "localhost:2181", server)
+}
+
+func TestExhibitorLister(t *testing.T) {
+	status := http.StatusOK
+	body := `{"servers":["10.0.0.1","10.0.0.2"],"port":2181}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/exhibitor/v1/cluster/list", r.URL.Path)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	list := newExhibitorLister(ts.URL+"/", ts.Client())
+	servers, err := list()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1:2181", "10.0.0.2:2181"}, servers)
+
+	body = `not json`
+	_, err = list()
+	assert.NotNil(t, err)
+
+	status = http.StatusServiceUnavailable
+	_, err = list()
+	assert.NotNil(t, err)
+}
+
+func TestSRVLister(t *testing.T) {
+	var err error
+	list := newSRVLister("prod.example.com", func(service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "zookeeper", service)
+		assert.Equal(t, "tcp", proto)
+		assert.Equal(t, "prod.example.com", name)
+		return "_zookeeper._tcp.prod.example.com.", []*net.SRV{
+			{Target: "zk1.prod.example.com.", Port: 2181},
+			{Target: "zk2.prod.example.com.", Port: 2182},
+		}, err
+	})
+
+	servers, err := list()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"zk1.prod.example.com:2181", "zk2.prod.example.com:2182"}, servers)
+
+	err = fmt.Errorf("no such host")
+	_, err = list()
+	assert.NotNil(t, err)
+}
+
+func TestPollingHostProviderStop(t *testing.T) {
+	listed := make(chan struct{}, 10)
+	hp := newPollingHostProvider(func() ([]string, error) {
+		listed <- struct{}{}
+		return []string{"zk1:2181"}, nil
+	}, 10*time.Millisecond)
+
+	assert.Nil(t, hp.Init([]string{"localhost:2181"}))
+	<-listed
+	<-listed
+	hp.Stop()
+	hp.Stop()
+	time.Sleep(20 * time.Millisecond)
+	for len(listed) != 0 {
+		<-listed
+	}
+	time.Sleep(30 * time.Millisecond)
+	assert.Len(t, listed, 0)
+
+	hp = newPollingHostProvider(func() ([]string, error) {
+		return []string{"zk1:2181"}, nil
+	}, 0)
+	assert.NotNil(t, hp.Init([]string{"localhost:2181"}))
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	lister_v1 "k8s.io/client-go/listers/core/v1"
@@ -15,6 +21,11 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 5 * time.Minute
+)
+
 func k8sGetClientConfig(kubeconfig string) (*rest.Config, error) {
 	if kubeconfig != "" {
 		return clientcmd.BuildConfigFromFlags("", kubeconfig)
@@ -37,18 +48,67 @@ func k8sGetClient(kubeconfig string) (*kubernetes.Clientset, error) {
 }
 
 type serviceController struct {
-	client        kubernetes.Interface
-	informer      cache.Controller
-	indexer       cache.Indexer
-	serviceLister lister_v1.ServiceLister
-	updater       *Updater
+	client            kubernetes.Interface
+	informer          cache.Controller
+	indexer           cache.Indexer
+	serviceLister     lister_v1.ServiceLister
+	endpointsInformer cache.Controller
+	endpointsIndexer  cache.Indexer
+	endpointsLister   lister_v1.EndpointsLister
+	nodeInformer      cache.Controller
+	nodeIndexer       cache.Indexer
+	nodeLister        lister_v1.NodeLister
+	namespaceInformer cache.Controller
+	namespaceIndexer  cache.Indexer
+	namespaceLister   lister_v1.NamespaceLister
+	nodeAddressTypes  []v1.NodeAddressType
+	repairInterval    time.Duration
+	persistent        bool
+	clusterName       string
+	config            *announserConfig
+	queue             *workQueue
+	updater           *Updater
+
+	recorder   func(key, eventType, reason, message string) // records events on services
+	rejectedMu sync.Mutex
+	rejected   map[string]string // reason services are not announsed
+
+	conflictsMu sync.Mutex
+	conflicts   map[string]string // path conflicts of services
+}
+
+// controllerOptions configures the service controller
+type controllerOptions struct {
+	namespace        string
+	updateInterval   time.Duration // interval to update the informer cache
+	repairInterval   time.Duration // interval to repair drift with zookeeper
+	nodeAddressTypes []v1.NodeAddressType
+	zookeeper        zooConfig
+	clusterName      string // recorded as the owner of persistent members
+	config           *announserConfig
 }
 
-func newServiceController(client kubernetes.Interface, namespace string, updateInterval time.Duration, zookeeperAddr string) *serviceController {
+func newServiceController(client kubernetes.Interface, options controllerOptions) *serviceController {
+	namespace := options.namespace
+	updateInterval := options.updateInterval
 	sc := &serviceController{
-		client: client,
+		client:           client,
+		nodeAddressTypes: options.nodeAddressTypes,
+		repairInterval:   options.repairInterval,
+		persistent:       options.zookeeper.persistent,
+		clusterName:      options.clusterName,
+		config:           options.config,
+		queue:            newWorkQueue(retryBaseDelay, retryMaxDelay),
+	}
+	sc.updater = newUpdater(options.zookeeper, options.config.Ensembles)
+	sc.updater.resync = func() {
+		sc.enqueueServices(func(*v1.Service) bool { return true })
+	}
+	sc.recorder = sc.recordEvent
+	sc.updater.tampered = func(key, message string) {
+		sc.recordEvent(key, v1.EventTypeWarning, eventReasonTampered, message)
+		sc.queue.Add(key)
 	}
-	sc.updater = newUpdater(zookeeperAddr)
 
 	indexer, informer := cache.NewIndexerInformer(
 		&cache.ListWatch{
@@ -68,15 +128,9 @@ func newServiceController(client kubernetes.Interface, namespace string, updateI
 			AddFunc: func(obj interface{}) {
 				if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
 					log.Debugf("addFunc key: %v", key)
-					service := obj.(*v1.Service)
-					event, err := newUpdaterEvent(eventCreate, service)
-					if err != nil {
-						log.Debugf("failed to generate new updater event: %v", err.Error())
-					} else {
-						sc.updater.events <- *event
-					}
-
+					sc.queue.Add(key)
 				}
+				sc.enqueuePathServices(obj)
 			},
 			UpdateFunc: func(old, new interface{}) {
 				if key, err := cache.MetaNamespaceKeyFunc(new); err == nil {
@@ -85,44 +139,563 @@ func newServiceController(client kubernetes.Interface, namespace string, updateI
 					oldService := old.(*v1.Service)
 
 					if newService.ResourceVersion != oldService.ResourceVersion {
-						event, err := newUpdaterEvent(eventUpdate, newService)
-						if err != nil {
-							log.Debugf("failed to generate new updater event: %v", err.Error())
-						} else {
-							sc.updater.events <- *event
-						}
+						sc.queue.Add(key)
+						sc.enqueuePathServices(oldService)
+						sc.enqueuePathServices(newService)
 					}
 				}
 			},
 			DeleteFunc: func(obj interface{}) {
 				if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
 					log.Debugf("deleteFunc key: %v", key)
-					service := obj.(*v1.Service)
-					event, err := newUpdaterEvent(eventDelete, service)
-					if err != nil {
-						log.Debugf("failed to generate new updater event: %v", err.Error())
-					} else {
-						sc.updater.events <- *event
-					}
+					sc.queue.Add(key)
 				}
+				sc.enqueuePathServices(obj)
 			},
 		},
-		cache.Indexers{},
+		cache.Indexers{servicePathIndex: servicePathIndexFunc},
 	)
 
 	sc.informer = informer
 	sc.indexer = indexer
 	sc.serviceLister = lister_v1.NewServiceLister(indexer)
 
+	endpointsIndexer, endpointsInformer := cache.NewIndexerInformer(
+		&cache.ListWatch{
+			ListFunc: func(lo metav1.ListOptions) (runtime.Object, error) {
+				return client.Core().Endpoints(namespace).List(lo)
+			},
+			WatchFunc: func(lo metav1.ListOptions) (watch.Interface, error) {
+				return client.Core().Endpoints(namespace).Watch(lo)
+			},
+		},
+		&v1.Endpoints{},
+		updateInterval,
+		// endpoints only matter for headless services with one member per pod
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				sc.handleEndpoints(obj)
+			},
+			UpdateFunc: func(old, new interface{}) {
+				newEndpoints := new.(*v1.Endpoints)
+				oldEndpoints := old.(*v1.Endpoints)
+				if newEndpoints.ResourceVersion != oldEndpoints.ResourceVersion {
+					sc.handleEndpoints(new)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				sc.handleEndpoints(obj)
+			},
+		},
+		cache.Indexers{},
+	)
+
+	sc.endpointsInformer = endpointsInformer
+	sc.endpointsIndexer = endpointsIndexer
+	sc.endpointsLister = lister_v1.NewEndpointsLister(endpointsIndexer)
+
+	nodeIndexer, nodeInformer := cache.NewIndexerInformer(
+		&cache.ListWatch{
+			ListFunc: func(lo metav1.ListOptions) (runtime.Object, error) {
+				return client.Core().Nodes().List(lo)
+			},
+			WatchFunc: func(lo metav1.ListOptions) (watch.Interface, error) {
+				return client.Core().Nodes().Watch(lo)
+			},
+		},
+		&v1.Node{},
+		updateInterval,
+		// nodes only matter for NodePort services with one member per node
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				sc.enqueueNodePortServices()
+			},
+			UpdateFunc: func(old, new interface{}) {
+				newNode := new.(*v1.Node)
+				oldNode := old.(*v1.Node)
+				// node status is updated on every heartbeat, only react to
+				// changes of the announced state
+				if isNodeAnnouncable(newNode) != isNodeAnnouncable(oldNode) ||
+					getNodeAddr(newNode, sc.nodeAddressTypes) != getNodeAddr(oldNode, sc.nodeAddressTypes) {
+					sc.enqueueNodePortServices()
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				sc.enqueueNodePortServices()
+			},
+		},
+		cache.Indexers{},
+	)
+
+	sc.nodeInformer = nodeInformer
+	sc.nodeIndexer = nodeIndexer
+	sc.nodeLister = lister_v1.NewNodeLister(nodeIndexer)
+
+	namespaceIndexer, namespaceInformer := cache.NewIndexerInformer(
+		&cache.ListWatch{
+			ListFunc: func(lo metav1.ListOptions) (runtime.Object, error) {
+				return client.Core().Namespaces().List(lo)
+			},
+			WatchFunc: func(lo metav1.ListOptions) (watch.Interface, error) {
+				return client.Core().Namespaces().Watch(lo)
+			},
+		},
+		&v1.Namespace{},
+		updateInterval,
+		// namespace labels select the paths the services of a namespace may use
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				newNamespace := new.(*v1.Namespace)
+				oldNamespace := old.(*v1.Namespace)
+				if !reflect.DeepEqual(newNamespace.GetLabels(), oldNamespace.GetLabels()) {
+					sc.enqueueNamespaceServices(newNamespace.GetName())
+				}
+			},
+		},
+		cache.Indexers{},
+	)
+
+	sc.namespaceInformer = namespaceInformer
+	sc.namespaceIndexer = namespaceIndexer
+	sc.namespaceLister = lister_v1.NewNamespaceLister(namespaceIndexer)
+
 	return sc
 }
 
+// newUpdaterEvent returns the event bringing zookeeper in line with the
+// current state of the service in the cache
+func (c *serviceController) newUpdaterEvent(key string) (*UpdaterEvent, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, err
+	}
+	event := UpdaterEvent{
+		eventType: eventDelete,
+		name:      key,
+	}
+
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		c.acceptService(key)
+		c.setConflict(key, "")
+		return &event, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if c.persistent {
+		event.owner = c.newMemberOwner(service, "")
+		event.path, _ = c.config.Paths.check(service.GetAnnotations()[serviceAnnotationPath])
+		event.ownedPaths = getMemberPaths(service)
+	}
+	if service.GetDeletionTimestamp() != nil {
+		log.Debugf("service %v is being deleted", key)
+		c.setConflict(key, "")
+		return &event, nil
+	}
+
+	members, err := c.getServiceMembers(key, service)
+	if err != nil {
+		log.Debugf("service will not be announsed: %v", err.Error())
+		return &event, nil
+	}
+	event.eventType = eventUpdate
+	event.members = members
+	event.ensembles = getServiceEnsembles(service)
+	return &event, nil
+}
+
+// getServiceMembers returns the members wanted in zookeeper for the service
+func (c *serviceController) getServiceMembers(key string, service *v1.Service) ([]*zkMember, error) {
+	conflict := ""
+	defer func() { c.setConflict(key, conflict) }()
+
+	err := checkRequiredServiceFieldsExists(service)
+	if err != nil {
+		return nil, fmt.Errorf("error service %v, err: %v", service.GetName(), err.Error())
+	}
+
+	annotations := service.GetAnnotations()
+	portname := annotations[serviceAnnotationPortName]
+
+	memberPath, err := c.config.Paths.check(annotations[serviceAnnotationPath])
+	if err != nil {
+		c.rejectService(key, fmt.Sprintf("invalid zookeeper path: %v", err.Error()))
+		return nil, fmt.Errorf("error service %v, err: invalid zookeeper path: %v", service.GetName(), err.Error())
+	}
+	err = checkNamespacePath(c.config.NamespacePaths, service.GetNamespace(), c.getNamespaceLabels(service.GetNamespace()), memberPath)
+	if err != nil {
+		c.rejectService(key, err.Error())
+		return nil, fmt.Errorf("error service %v, err: %v", service.GetName(), err.Error())
+	}
+	ensembles := getServiceEnsembles(service)
+	if len(ensembles) == 0 {
+		c.rejectService(key, fmt.Sprintf("no ensemble in annotation %v", serviceAnnotationEnsembles))
+		return nil, fmt.Errorf("error service %v, err: no ensemble in annotation %v", service.GetName(), serviceAnnotationEnsembles)
+	}
+	for _, ensemble := range ensembles {
+		if _, ok := c.config.Ensembles[ensemble]; !ok && ensemble != defaultEnsemble {
+			c.rejectService(key, fmt.Sprintf("unknown ensemble %v", ensemble))
+			return nil, fmt.Errorf("error service %v, err: unknown ensemble %v", service.GetName(), ensemble)
+		}
+	}
+	conflict, err = c.checkPathConflict(key, service, memberPath)
+	if err != nil {
+		c.rejectService(key, err.Error())
+		return nil, fmt.Errorf("error service %v, err: %v", service.GetName(), err.Error())
+	}
+	var policy *aclPolicy
+	if name, ok := annotations[serviceAnnotationACL]; ok {
+		if policy, ok = c.config.ACLPolicies[name]; !ok {
+			c.rejectService(key, fmt.Sprintf("unknown acl policy %v", name))
+			return nil, fmt.Errorf("error service %v, err: unknown acl policy %v", service.GetName(), name)
+		}
+	}
+	c.acceptService(key)
+
+	var members []*zkMember
+	if isHeadlessService(service) {
+		members = getEndpointsMembers(portname, c.getEndpoints(service))
+	} else {
+		port := getServicePortByName(portname, service)
+		if port == nil {
+			return nil, fmt.Errorf("service named missing port")
+		}
+		allIngress, err := announceAllIngress(service)
+		if err != nil {
+			return nil, fmt.Errorf("error service %v, err: %v", service.GetName(), err.Error())
+		}
+		if service.Spec.Type == v1.ServiceTypeNodePort {
+			members = getNodePortMembers(port, c.getNodes(), c.nodeAddressTypes)
+		} else if allIngress {
+			for _, serviceAddr := range getServiceAddrs(service) {
+				member := newZKMember()
+				member.id = serviceAddr
+				member.addServiceEndpoint(portname, serviceAddr, int(port.Port))
+				members = append(members, member)
+			}
+		} else if serviceAddr := getServiceAddr(service); serviceAddr != "" {
+			member := newZKMember()
+			member.id = serviceAddr
+			member.addServiceEndpoint(
+				portname,
+				serviceAddr,
+				int(port.Port),
+			)
+			members = append(members, member)
+		}
+		if len(members) == 0 {
+			log.Debugf("service %v missing %v address", service.GetName(), service.Spec.Type)
+		}
+	}
+
+	for _, member := range members {
+		member.path = memberPath
+		member.name = key
+		member.uid = string(service.GetUID())
+		member.prefix = service.GetResourceVersion()
+		if c.persistent {
+			member.Owner = c.newMemberOwner(service, member.id)
+		}
+		if policy != nil {
+			member.acl = policy.acl
+			if policy.Parents {
+				member.parentACL = policy.acl
+			}
+		}
+	}
+	return members, nil
+}
+
+// newMemberOwner returns the owner recorded in the data of the persistent
+// member id of the service
+func (c *serviceController) newMemberOwner(service *v1.Service, id string) *memberOwner {
+	return &memberOwner{
+		Cluster:   c.clusterName,
+		Namespace: service.GetNamespace(),
+		Name:      service.GetName(),
+		UID:       string(service.GetUID()),
+		ID:        id,
+	}
+}
+
+// enqueueServices adds the keys of all cached services matching filter to the queue
+func (c *serviceController) enqueueServices(filter func(*v1.Service) bool) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		log.Debugf("failed to list services: %v", err.Error())
+		return
+	}
+	for _, service := range services {
+		if !filter(service) {
+			continue
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(service); err == nil {
+			c.queue.Add(key)
+		}
+	}
+}
+
+// enqueueNamespaceServices adds all services of the namespace to the queue
+func (c *serviceController) enqueueNamespaceServices(namespace string) {
+	c.enqueueServices(func(service *v1.Service) bool {
+		return service.GetNamespace() == namespace
+	})
+}
+
+// getNamespaceLabels returns the labels of the namespace from the namespace cache
+func (c *serviceController) getNamespaceLabels(name string) labels.Set {
+	namespace, err := c.namespaceLister.Get(name)
+	if err != nil {
+		log.Debugf("failed to get namespace %v: %v", name, err.Error())
+		return nil
+	}
+	return labels.Set(namespace.GetLabels())
+}
+
+// rejectService logs the reason the service is not announsed and records it
+// as an event on the service, both only when the reason changed
+func (c *serviceController) rejectService(key, reason string) {
+	c.rejectedMu.Lock()
+	if c.rejected == nil {
+		c.rejected = make(map[string]string)
+	}
+	changed := c.rejected[key] != reason
+	c.rejected[key] = reason
+	c.rejectedMu.Unlock()
+	if !changed {
+		return
+	}
+	log.Warnf("service %v will not be announsed: %v", key, reason)
+	if c.recorder != nil {
+		c.recorder(key, v1.EventTypeWarning, eventReasonRejected, reason)
+	}
+}
+
+// acceptService forgets the reason the service was rejected
+func (c *serviceController) acceptService(key string) {
+	c.rejectedMu.Lock()
+	defer c.rejectedMu.Unlock()
+	delete(c.rejected, key)
+}
+
+// enqueueNodePortServices adds all NodePort services to the queue
+func (c *serviceController) enqueueNodePortServices() {
+	c.enqueueServices(func(service *v1.Service) bool {
+		return service.Spec.Type == v1.ServiceTypeNodePort
+	})
+}
+
+// getNodes returns all nodes from the node cache
+func (c *serviceController) getNodes() []*v1.Node {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		log.Debugf("failed to list nodes: %v", err.Error())
+	}
+	return nodes
+}
+
+// getEndpoints returns the endpoints of a headless service or nil
+func (c *serviceController) getEndpoints(service *v1.Service) *v1.Endpoints {
+	if !isHeadlessService(service) {
+		return nil
+	}
+	endpoints, err := c.endpointsLister.Endpoints(service.GetNamespace()).Get(service.GetName())
+	if err != nil {
+		log.Debugf("failed to get endpoints for service %v: %v", service.GetName(), err.Error())
+		return nil
+	}
+	return endpoints
+}
+
+// handleEndpoints queues the service of the endpoints if it is headless
+func (c *serviceController) handleEndpoints(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	log.Debugf("endpoints key: %v", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil || !isHeadlessService(service) {
+		return
+	}
+	c.queue.Add(key)
+}
+
 func (c *serviceController) Run(stopCh chan struct{}) {
 	log.Info("Starting serviceController")
+	defer c.queue.ShutDown()
+
+	err := c.updater.Connect()
+	if err != nil {
+		log.Errorf("failed to connect to zookeeper: %v", err.Error())
+		return
+	}
 
 	go c.informer.Run(stopCh)
+	go c.endpointsInformer.Run(stopCh)
+	go c.nodeInformer.Run(stopCh)
+	go c.namespaceInformer.Run(stopCh)
 	go c.updater.Run(stopCh)
 
+	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced, c.endpointsInformer.HasSynced, c.nodeInformer.HasSynced, c.namespaceInformer.HasSynced) {
+		log.Error("timed out waiting for caches to sync")
+		return
+	}
+	c.reconcile()
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+	go c.runRepair(stopCh)
+
 	<-stopCh
 	log.Info("Stopping serviceController")
 }
+
+// reconcile brings zookeeper in line with all announsed services once the
+// cache is synced, before the queue is processed
+func (c *serviceController) reconcile() {
+	log.Info("reconciling services with zookeeper")
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("failed to list services: %v", err.Error())
+		return
+	}
+	for _, service := range services {
+		key, err := cache.MetaNamespaceKeyFunc(service)
+		if err != nil {
+			continue
+		}
+		event, err := c.newUpdaterEvent(key)
+		if err != nil || event.eventType != eventUpdate {
+			continue
+		}
+		if c.persistent {
+			err = c.addFinalizer(key, event.path)
+			if err != nil {
+				log.Errorf("failed to add finalizer to service %v: %v", key, err.Error())
+				c.queue.AddRateLimited(key)
+				continue
+			}
+		}
+		err = c.updater.Reconcile(event)
+		if err != nil {
+			log.Errorf("failed to reconcile service %v: %v", key, err.Error())
+			c.queue.AddRateLimited(key)
+		}
+	}
+}
+
+// runRepair finds drift between the cache and zookeeper every repairInterval
+func (c *serviceController) runRepair(stopCh chan struct{}) {
+	ticker := time.NewTicker(c.repairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.repair()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// repair compares the members of all announsed services with zookeeper and
+// queues the services that drifted, the queue writes the fix
+func (c *serviceController) repair() {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("failed to list services: %v", err.Error())
+		return
+	}
+	drifted := 0
+	announsed := make(map[string]bool)
+	for _, service := range services {
+		key, err := cache.MetaNamespaceKeyFunc(service)
+		if err != nil {
+			continue
+		}
+		event, err := c.newUpdaterEvent(key)
+		if err != nil || event.eventType != eventUpdate {
+			continue
+		}
+		announsed[key] = true
+		n, err := c.updater.CheckDrift(event)
+		if err != nil {
+			log.Errorf("failed to check service %v: %v", key, err.Error())
+		}
+		if n != 0 || err != nil {
+			drifted += n
+			c.queue.Add(key)
+		}
+	}
+	for _, key := range c.updater.ActiveServices() {
+		if !announsed[key] {
+			log.Infof("repair service: %v no longer announsed", key)
+			c.queue.Add(key)
+			drifted++
+		}
+	}
+	if drifted != 0 {
+		log.Infof("found %v drifted members", drifted)
+	} else {
+		log.Debugf("no drift between services and zookeeper")
+	}
+}
+
+func (c *serviceController) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+// processNextItem syncs the next service key in the queue, failed keys are
+// added back to the queue with backoff
+func (c *serviceController) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncService(key)
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+	log.Errorf("failed to sync service %v (retry %v): %v", key, c.queue.NumRequeues(key), err.Error())
+	c.queue.AddRateLimited(key)
+	return true
+}
+
+// syncService applies the state of the service to zookeeper. Persistent
+// members are only written once the service has the finalizer, and the
+// finalizer is removed once the members are deleted
+func (c *serviceController) syncService(key string) error {
+	event, err := c.newUpdaterEvent(key)
+	if err != nil {
+		return err
+	}
+	if c.persistent && event.eventType == eventUpdate {
+		err = c.addFinalizer(key, event.path)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.updater.Process(event)
+	if err != nil {
+		return err
+	}
+	err = c.updateConflictAnnotation(key)
+	if err != nil {
+		return err
+	}
+	if event.eventType == eventDelete {
+		return c.removeFinalizer(key)
+	}
+	if c.persistent {
+		return c.pruneMemberPaths(key, event.path)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	lister_v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(namespace, name, uid, clusterIP string, annotations map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			UID:         types.UID(uid),
+			Annotations: annotations,
+		},
+		Spec: v1.ServiceSpec{
+			Type:      "ClusterIP",
+			ClusterIP: clusterIP,
+			Ports: []v1.ServicePort{
+				{
+					Name: "http",
+					Port: 80,
+				},
+			},
+		},
+	}
+}
+
+func newTestController(services ...*v1.Service) *serviceController {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{servicePathIndex: servicePathIndexFunc})
+	for _, service := range services {
+		indexer.Add(service)
+	}
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	return &serviceController{
+		indexer:          indexer,
+		serviceLister:    lister_v1.NewServiceLister(indexer),
+		endpointsLister:  lister_v1.NewEndpointsLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		nodeLister:       lister_v1.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		namespaceIndexer: namespaceIndexer,
+		namespaceLister:  lister_v1.NewNamespaceLister(namespaceIndexer),
+		config:           newAnnounserConfig(),
+	}
+}
+
+func TestNewUpdaterEventNamespaces(t *testing.T) {
+	annotations := map[string]string{
+		serviceAnnotationPath:     "/aurora/jobs/api",
+		serviceAnnotationPortName: "http",
+	}
+	c := newTestController(
+		newTestService("dev", "api", "uid-dev", "10.0.0.1", annotations),
+		newTestService("prod", "api", "uid-prod", "10.0.0.2", annotations),
+		newTestService("test", "api", "uid-test", "10.0.0.3", nil),
+	)
+
+	testCases := []struct {
+		testName  string
+		key       string
+		eventType string
+		host      string
+		uid       string
+	}{
+		{testName: "dev service", key: "dev/api", eventType: eventUpdate, host: "10.0.0.1", uid: "uid-dev"},
+		{testName: "prod service", key: "prod/api", eventType: eventUpdate, host: "10.0.0.2", uid: "uid-prod"},
+		{testName: "not announsed service", key: "test/api", eventType: eventDelete},
+		{testName: "deleted service", key: "stage/api", eventType: eventDelete},
+	}
+
+	for _, tc := range testCases {
+		event, err := c.newUpdaterEvent(tc.key)
+		assert.Nil(t, err, tc.testName)
+		assert.Equal(t, tc.eventType, event.eventType, tc.testName)
+		assert.Equal(t, tc.key, event.name, tc.testName)
+		if tc.eventType != eventUpdate {
+			assert.Empty(t, event.members, tc.testName)
+			continue
+		}
+		assert.Len(t, event.members, 1, tc.testName)
+		assert.Equal(t, tc.key, event.members[0].name, tc.testName)
+		assert.Equal(t, tc.uid, event.members[0].uid, tc.testName)
+		assert.Equal(t, tc.host, event.members[0].ServiceEndpoint.Host, tc.testName)
+	}
+}
+
+func TestNewUpdaterEventACLPolicy(t *testing.T) {
+	newAnnotations := func(policy string) map[string]string {
+		return map[string]string{
+			serviceAnnotationPath:     "/aurora/jobs/api",
+			serviceAnnotationPortName: "http",
+			serviceAnnotationACL:      policy,
+		}
+	}
+	c := newTestController(
+		newTestService("dev", "members", "uid-1", "10.0.0.1", newAnnotations("members")),
+		newTestService("dev", "parents", "uid-2", "10.0.0.2", newAnnotations("parents")),
+		newTestService("dev", "unknown", "uid-3", "10.0.0.3", newAnnotations("unknown")),
+	)
+	assert.Nil(t, parseConfig([]byte(`
+aclPolicies:
+  members:
+    acl: "auth::cdrwa,ip:10.1.0.0/16:r"
+  parents:
+    acl: "auth::cdrwa"
+    parents: true
+`), c.config))
+	var events []string
+	c.recorder = func(key, eventType, reason, message string) {
+		events = append(events, key+" "+reason)
+	}
+
+	event, err := c.newUpdaterEvent("dev/members")
+	assert.Nil(t, err)
+	assert.Equal(t, eventUpdate, event.eventType)
+	assert.Equal(t, c.config.ACLPolicies["members"].acl, event.members[0].acl)
+	assert.Nil(t, event.members[0].parentACL)
+
+	event, err = c.newUpdaterEvent("dev/parents")
+	assert.Nil(t, err)
+	assert.Equal(t, c.config.ACLPolicies["parents"].acl, event.members[0].acl)
+	assert.Equal(t, c.config.ACLPolicies["parents"].acl, event.members[0].parentACL)
+
+	event, err = c.newUpdaterEvent("dev/unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, eventDelete, event.eventType)
+	assert.Equal(t, []string{"dev/unknown " + eventReasonRejected}, events)
+}
+
+func TestNewUpdaterEventPersistent(t *testing.T) {
+	annotations := map[string]string{
+		serviceAnnotationPath:     "/aurora/jobs/api",
+		serviceAnnotationPortName: "http",
+	}
+	deleted := newTestService("dev", "deleted", "uid-2", "10.0.0.2", map[string]string{
+		serviceAnnotationPath:        "/aurora/jobs/api",
+		serviceAnnotationPortName:    "http",
+		serviceAnnotationMemberPaths: "/aurora/jobs/api,/aurora/jobs/old",
+	})
+	now := metav1.Now()
+	deleted.SetDeletionTimestamp(&now)
+	c := newTestController(
+		newTestService("dev", "api", "uid-1", "10.0.0.1", annotations),
+		deleted,
+	)
+	c.persistent = true
+	c.clusterName = "prod"
+
+	event, err := c.newUpdaterEvent("dev/api")
+	assert.Nil(t, err)
+	assert.Equal(t, eventUpdate, event.eventType)
+	assert.Equal(t, &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-1", ID: "10.0.0.1"}, event.members[0].Owner)
+
+	event, err = c.newUpdaterEvent("dev/deleted")
+	assert.Nil(t, err)
+	assert.Equal(t, eventDelete, event.eventType)
+	assert.Equal(t, "/aurora/jobs/api", event.path)
+	assert.Equal(t, &memberOwner{Cluster: "prod", Namespace: "dev", Name: "deleted", UID: "uid-2"}, event.owner)
+	assert.Equal(t, []string{"/aurora/jobs/api", "/aurora/jobs/old"}, event.ownedPaths)
+}
+
+func TestNewUpdaterEventInvalidPath(t *testing.T) {
+	c := newTestController(
+		newTestService("dev", "api", "uid-1", "10.0.0.1", map[string]string{
+			serviceAnnotationPath:     "/aurora/jobs/api/",
+			serviceAnnotationPortName: "http",
+		}),
+		newTestService("dev", "reserved", "uid-2", "10.0.0.2", map[string]string{
+			serviceAnnotationPath:     "/zookeeper",
+			serviceAnnotationPortName: "http",
+		}),
+	)
+
+	event, err := c.newUpdaterEvent("dev/api")
+	assert.Nil(t, err)
+	assert.Equal(t, eventUpdate, event.eventType)
+	assert.Equal(t, "/aurora/jobs/api", event.members[0].path)
+
+	event, err = c.newUpdaterEvent("dev/reserved")
+	assert.Nil(t, err)
+	assert.Equal(t, eventDelete, event.eventType)
+}
+
+func TestNewUpdaterEventNamespacePaths(t *testing.T) {
+	newAnnotations := func(path string) map[string]string {
+		return map[string]string{
+			serviceAnnotationPath:     path,
+			serviceAnnotationPortName: "http",
+		}
+	}
+	c := newTestController(
+		newTestService("dev", "api", "uid-1", "10.0.0.1", newAnnotations("/aurora/jobs/dev/api")),
+		newTestService("dev", "payments", "uid-2", "10.0.0.2", newAnnotations("/aurora/jobs/payments/prod/api")),
+		newTestService("payments", "api", "uid-3", "10.0.0.3", newAnnotations("/aurora/jobs/payments/prod/api")),
+		newTestService("test", "api", "uid-4", "10.0.0.4", newAnnotations("/aurora/jobs/test/api")),
+	)
+	c.namespaceIndexer.Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}},
+	})
+	assert.Nil(t, parseConfig([]byte(`
+namespacePaths:
+  - namespaces: ["dev"]
+    prefixes: ["/aurora/jobs/dev"]
+  - namespaceSelector:
+      matchLabels:
+        team: payments
+    prefixes: ["/aurora/jobs/payments"]
+`), c.config))
+	var events []string
+	c.recorder = func(key, eventType, reason, message string) {
+		events = append(events, key+" "+reason)
+	}
+
+	testCases := []struct {
+		testName  string
+		key       string
+		eventType string
+	}{
+		{testName: "allowed namespace name", key: "dev/api", eventType: eventUpdate},
+		{testName: "disallowed path of namespace", key: "dev/payments", eventType: eventDelete},
+		{testName: "allowed namespace labels", key: "payments/api", eventType: eventUpdate},
+		{testName: "namespace without rule", key: "test/api", eventType: eventDelete},
+	}
+
+	for _, tc := range testCases {
+		event, err := c.newUpdaterEvent(tc.key)
+		assert.Nil(t, err, tc.testName)
+		assert.Equal(t, tc.eventType, event.eventType, tc.testName)
+	}
+
+	// rejections are only recorded once
+	c.newUpdaterEvent("dev/payments")
+	assert.Equal(t, []string{"dev/payments " + eventReasonRejected, "test/api " + eventReasonRejected}, events)
+}
+
+func TestNewUpdaterEventPathConflicts(t *testing.T) {
+	annotations := map[string]string{
+		serviceAnnotationPath:     "/aurora/jobs/api",
+		serviceAnnotationPortName: "http",
+	}
+	created := time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC)
+	older := newTestService("dev", "api", "uid-1", "10.0.0.1", annotations)
+	older.SetCreationTimestamp(metav1.NewTime(created))
+	newer := newTestService("prod", "api", "uid-2", "10.0.0.2", annotations)
+	newer.SetCreationTimestamp(metav1.NewTime(created.Add(time.Minute)))
+	other := newTestService("prod", "web", "uid-3", "10.0.0.3", map[string]string{
+		serviceAnnotationPath:     "/aurora/jobs/web",
+		serviceAnnotationPortName: "http",
+	})
+
+	testCases := []struct {
+		testName  string
+		policy    string
+		key       string
+		eventType string
+		conflict  bool
+	}{
+		{testName: "warn older", policy: conflictPolicyWarn, key: "dev/api", eventType: eventUpdate, conflict: true},
+		{testName: "warn newer", policy: conflictPolicyWarn, key: "prod/api", eventType: eventUpdate, conflict: true},
+		{testName: "warn without conflict", policy: conflictPolicyWarn, key: "prod/web", eventType: eventUpdate},
+		{testName: "default newer", key: "prod/api", eventType: eventUpdate, conflict: true},
+		{testName: "reject older", policy: conflictPolicyRejectNewer, key: "dev/api", eventType: eventUpdate, conflict: true},
+		{testName: "reject newer", policy: conflictPolicyRejectNewer, key: "prod/api", eventType: eventDelete, conflict: true},
+		{testName: "allow newer", policy: conflictPolicyAllow, key: "prod/api", eventType: eventUpdate},
+	}
+
+	for _, tc := range testCases {
+		c := newTestController(older, newer, other)
+		c.config.PathConflicts = tc.policy
+		event, err := c.newUpdaterEvent(tc.key)
+		assert.Nil(t, err, tc.testName)
+		assert.Equal(t, tc.eventType, event.eventType, tc.testName)
+		assert.Equal(t, tc.conflict, c.getConflict(tc.key) != "", tc.testName)
+	}
+}
+
+func TestNewUpdaterEventEnsembles(t *testing.T) {
+	newAnnotations := func(ensembles string) map[string]string {
+		return map[string]string{
+			serviceAnnotationPath:      "/aurora/jobs/api",
+			serviceAnnotationPortName:  "http",
+			serviceAnnotationEnsembles: ensembles,
+		}
+	}
+	c := newTestController(
+		newTestService("dev", "api", "uid-1", "10.0.0.1", map[string]string{
+			serviceAnnotationPath:     "/aurora/jobs/api",
+			serviceAnnotationPortName: "http",
+		}),
+		newTestService("prod", "api", "uid-2", "10.0.0.2", newAnnotations("us-east-prod,eu-prod")),
+		newTestService("test", "api", "uid-3", "10.0.0.3", newAnnotations("us-west-prod")),
+		newTestService("test", "web", "uid-4", "10.0.0.4", newAnnotations(" , ")),
+	)
+	var events []string
+	c.recorder = func(key, eventType, reason, message string) {
+		events = append(events, key+" "+message)
+	}
+	assert.Nil(t, parseConfig([]byte(`
+ensembles:
+  us-east-prod:
+    connectString: "zk1.us-east:2181"
+  eu-prod:
+    connectString: "zk1.eu:2181"
+`), c.config))
+
+	event, err := c.newUpdaterEvent("dev/api")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{defaultEnsemble}, event.ensembles)
+
+	event, err = c.newUpdaterEvent("prod/api")
+	assert.Nil(t, err)
+	assert.Equal(t, eventUpdate, event.eventType)
+	assert.Equal(t, []string{"eu-prod", "us-east-prod"}, event.ensembles)
+	// the same path in other ensembles is no conflict
+	assert.Equal(t, "", c.getConflict("prod/api"))
+
+	event, err = c.newUpdaterEvent("test/api")
+	assert.Nil(t, err)
+	assert.Equal(t, eventDelete, event.eventType)
+
+	// an empty selection is rejected instead of deleting the members everywhere
+	event, err = c.newUpdaterEvent("test/web")
+	assert.Nil(t, err)
+	assert.Equal(t, eventDelete, event.eventType)
+	assert.Equal(t, []string{
+		"test/api unknown ensemble us-west-prod",
+		"test/web no ensemble in annotation " + serviceAnnotationEnsembles,
+	}, events)
+}
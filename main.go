@@ -1,8 +1,10 @@
 package main
 
 import (
+	_ "expvar"
 	"flag"
 	"fmt"
+	"net/http"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,13 +14,39 @@ import (
 
 func main() {
 	var kubeconfig string
+	var configFile string
 	var debug bool
 	var zookeeperAddr string
+	var zookeeperAuthFile string
+	var zookeeperExhibitor string
+	var zookeeperSRVDomain string
+	var zookeeperDiscoveryInterval time.Duration
+	var zookeeperACL string
+	var nodeAddressTypes string
+	var persistentMembers bool
+	var deterministicNames bool
+	var cleanupParents bool
+	var clusterName string
 	var updateInterval time.Duration
+	var repairInterval time.Duration
+	var metricsAddr string
 
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig file")
-	flag.StringVar(&zookeeperAddr, "zookeeper.addr", "localhost:2181", "zookeeper address:port")
+	flag.StringVar(&configFile, "config", "", "Path to the announser config file")
+	flag.StringVar(&zookeeperAddr, "zookeeper.addr", "localhost:2181", "zookeeper connect string host1:port,host2:port[/chroot]")
+	flag.StringVar(&zookeeperExhibitor, "zookeeper.exhibitor", "", "exhibitor url, e.g. http://exhibitor:8080, to discover the zookeeper servers")
+	flag.StringVar(&zookeeperSRVDomain, "zookeeper.srv-domain", "", "domain with _zookeeper._tcp SRV records to discover the zookeeper servers")
+	flag.DurationVar(&zookeeperDiscoveryInterval, "zookeeper.discovery-interval", 30*time.Second, "interval to discover the zookeeper servers")
+	flag.StringVar(&zookeeperAuthFile, "zookeeper.auth-file", "", "file with user:password zookeeper digest credentials")
+	flag.StringVar(&zookeeperACL, "zookeeper.acl", defaultACL, "comma separated scheme:id:perms acl of created znodes, e.g. auth::cdrwa,world:anyone:r")
+	flag.StringVar(&nodeAddressTypes, "nodeport.address-types", "InternalIP,ExternalIP,Hostname", "comma separated node address types in order of preference used for NodePort services")
+	flag.BoolVar(&persistentMembers, "members.persistent", false, "write persistent members, deleted by the announser using service finalizers, instead of ephemeral members")
+	flag.BoolVar(&deterministicNames, "members.deterministic-names", false, "name members member_<cluster>_<namespace>_<name>_<index> instead of sequential names, so existing members are found again after a restart")
+	flag.BoolVar(&cleanupParents, "members.cleanup-parents", true, "delete the parent znodes created for members once they are empty, disable for paths shared with other writers")
+	flag.StringVar(&clusterName, "cluster.name", "kubernetes", "name of the cluster recorded as the owner of persistent members and used in deterministic member names")
 	flag.DurationVar(&updateInterval, "interval", 10*time.Second, "interavl to update the informer cache")
+	flag.DurationVar(&repairInterval, "repair.interval", 5*time.Minute, "interval to repair drift between services and zookeeper")
+	flag.StringVar(&metricsAddr, "metrics.addr", "", "address to serve the expvar metrics at /debug/vars, e.g. :8080, empty to disable")
 	flag.BoolVar(&debug, "debug", false, "debug logging")
 	flag.Set("logtostderr", "true")
 	flag.Parse()
@@ -30,10 +58,60 @@ func main() {
 		log.Error(fmt.Errorf("Failed to get client: %v", err))
 	}
 
+	config, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	addressTypes, err := parseNodeAddressTypes(nodeAddressTypes)
+	if err != nil {
+		log.Fatalf("invalid -nodeport.address-types: %v", err)
+	}
+
+	acl, err := parseACL(zookeeperACL)
+	if err != nil {
+		log.Fatalf("invalid -zookeeper.acl: %v", err)
+	}
+	if aclNeedsAuth(acl) && zookeeperAuthFile == "" {
+		log.Fatalf("-zookeeper.acl with the auth scheme requires -zookeeper.auth-file")
+	}
+	if zookeeperDiscoveryInterval <= 0 {
+		log.Fatalf("-zookeeper.discovery-interval must be positive")
+	}
+	if zookeeperExhibitor != "" && zookeeperSRVDomain != "" {
+		log.Fatalf("-zookeeper.exhibitor and -zookeeper.srv-domain can not be used together")
+	}
+	zookeeper := zooConfig{
+		connectString:     zookeeperAddr,
+		exhibitorURL:      zookeeperExhibitor,
+		srvDomain:         zookeeperSRVDomain,
+		discoveryInterval: zookeeperDiscoveryInterval,
+		authFile:          zookeeperAuthFile,
+		acl:               acl,
+		persistent:        persistentMembers,
+		deterministic:     deterministicNames,
+		cluster:           clusterName,
+		cleanupParents:    cleanupParents,
+	}
+
+	if metricsAddr != "" {
+		go func() {
+			log.Errorf("failed to serve metrics: %v", http.ListenAndServe(metricsAddr, nil))
+		}()
+	}
+
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
-	controller := newServiceController(client, metav1.NamespaceAll, updateInterval, zookeeperAddr)
+	controller := newServiceController(client, controllerOptions{
+		namespace:        metav1.NamespaceAll,
+		updateInterval:   updateInterval,
+		repairInterval:   repairInterval,
+		nodeAddressTypes: addressTypes,
+		zookeeper:        zookeeper,
+		clusterName:      clusterName,
+		config:           config,
+	})
 	controller.Run(stopCh)
 
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespacePathRule allows the namespaces matching the names or the label
+// selector to announse into the path prefixes
+//
+//	namespacePaths:
+//	  - namespaces: ["dev"]
+//	    prefixes: ["/aurora/jobs/dev"]
+//	  - namespaceSelector:
+//	      matchLabels:
+//	        team: payments
+//	    prefixes: ["/aurora/jobs/payments"]
+type namespacePathRule struct {
+	Namespaces        []string              `json:"namespaces"` // namespace names, * for all namespaces
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	Prefixes          []string              `json:"prefixes"`
+
+	selector labels.Selector
+}
+
+// validate normalizes the prefixes and parses the selector of the rule
+func (r *namespacePathRule) validate() error {
+	if len(r.Namespaces) == 0 && r.NamespaceSelector == nil {
+		return fmt.Errorf("rule without namespaces or namespaceSelector")
+	}
+	if len(r.Prefixes) == 0 {
+		return fmt.Errorf("rule without prefixes")
+	}
+	for i, prefix := range r.Prefixes {
+		normalized, err := normalizePath(prefix)
+		if err != nil {
+			return fmt.Errorf("prefixes: %v", err.Error())
+		}
+		r.Prefixes[i] = normalized
+	}
+	if r.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(r.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("namespaceSelector: %v", err.Error())
+		}
+		r.selector = selector
+	}
+	return nil
+}
+
+// matches returns true if the rule applies to the namespace with the labels
+func (r *namespacePathRule) matches(namespace string, namespaceLabels labels.Set) bool {
+	for _, name := range r.Namespaces {
+		if name == namespace || name == "*" {
+			return true
+		}
+	}
+	return r.selector != nil && namespaceLabels != nil && r.selector.Matches(namespaceLabels)
+}
+
+// checkNamespacePath returns why the namespace is not allowed to announse
+// into the path, nil when it is allowed. Without rules every path is allowed
+func checkNamespacePath(rules []*namespacePathRule, namespace string, namespaceLabels labels.Set, memberPath string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	var allowed []string
+	for _, rule := range rules {
+		if !rule.matches(namespace, namespaceLabels) {
+			continue
+		}
+		for _, prefix := range rule.Prefixes {
+			if isPathBelow(memberPath, prefix) {
+				return nil
+			}
+			allowed = append(allowed, prefix)
+		}
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("namespace %v is not allowed to announse into any path", namespace)
+	}
+	return fmt.Errorf("namespace %v is not allowed to announse into %v, allowed prefixes: %v", namespace, memberPath, strings.Join(allowed, ", "))
+}
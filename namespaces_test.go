@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckNamespacePath(t *testing.T) {
+	rules := []*namespacePathRule{
+		{Namespaces: []string{"dev"}, Prefixes: []string{"/aurora/jobs/dev/"}},
+		{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			Prefixes:          []string{"/aurora/jobs/payments", "/finagle/payments"},
+		},
+		{Namespaces: []string{"*"}, Prefixes: []string{"/aurora/jobs/shared"}},
+	}
+	for _, rule := range rules {
+		assert.Nil(t, rule.validate())
+	}
+	paymentsLabels := labels.Set{"team": "payments"}
+
+	testCases := []struct {
+		testName        string
+		rules           []*namespacePathRule
+		namespace       string
+		namespaceLabels labels.Set
+		path            string
+		expectedError   bool
+	}{
+		{testName: "no rules", namespace: "dev", path: "/aurora/jobs/payments/api"},
+		{testName: "namespace name", rules: rules, namespace: "dev", path: "/aurora/jobs/dev/api"},
+		{testName: "namespace name outside prefix", rules: rules, namespace: "dev", path: "/aurora/jobs/payments/api", expectedError: true},
+		{testName: "similar prefix", rules: rules, namespace: "dev", path: "/aurora/jobs/dev-api", expectedError: true},
+		{testName: "namespace labels", rules: rules, namespace: "billing", namespaceLabels: paymentsLabels, path: "/finagle/payments/api"},
+		{testName: "namespace labels outside prefix", rules: rules, namespace: "billing", namespaceLabels: paymentsLabels, path: "/aurora/jobs/dev/api", expectedError: true},
+		{testName: "all namespaces", rules: rules, namespace: "test", path: "/aurora/jobs/shared/api"},
+		{testName: "no matching prefix", rules: rules, namespace: "test", path: "/aurora/jobs/test/api", expectedError: true},
+		{testName: "no matching rule", rules: rules[:2], namespace: "test", path: "/aurora/jobs/test/api", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		err := checkNamespacePath(tc.rules, tc.namespace, tc.namespaceLabels, tc.path)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+		} else {
+			assert.Nil(t, err, tc.testName)
+		}
+	}
+}
+
+func TestNamespacePathRuleValidate(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		rule          namespacePathRule
+		expectedError bool
+	}{
+		{testName: "namespaces", rule: namespacePathRule{Namespaces: []string{"dev"}, Prefixes: []string{"/dev"}}},
+		{testName: "no namespaces", rule: namespacePathRule{Prefixes: []string{"/dev"}}, expectedError: true},
+		{testName: "no prefixes", rule: namespacePathRule{Namespaces: []string{"dev"}}, expectedError: true},
+		{testName: "relative prefix", rule: namespacePathRule{Namespaces: []string{"dev"}, Prefixes: []string{"dev"}}, expectedError: true},
+		{
+			testName: "invalid selector",
+			rule: namespacePathRule{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Unknown"}}},
+				Prefixes:          []string{"/dev"},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.rule.validate()
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+		} else {
+			assert.Nil(t, err, tc.testName)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+var (
+	knownNodeAddressTypes = []v1.NodeAddressType{
+		v1.NodeInternalIP,
+		v1.NodeExternalIP,
+		v1.NodeInternalDNS,
+		v1.NodeExternalDNS,
+		v1.NodeHostName,
+	}
+)
+
+// parseNodeAddressTypes parses a comma separated list of node address types
+// in order of preference
+func parseNodeAddressTypes(types string) ([]v1.NodeAddressType, error) {
+	var result []v1.NodeAddressType
+	for _, val := range strings.Split(types, ",") {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+		found := false
+		for _, known := range knownNodeAddressTypes {
+			if string(known) == val {
+				result = append(result, known)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown node address type %v", val)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no node address types given")
+	}
+	return result, nil
+}
+
+// isNodeAnnouncable returns true for ready nodes that are not cordoned
+func isNodeAnnouncable(node *v1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getNodeAddr returns the node address of the first matching address type
+func getNodeAddr(node *v1.Node, addressTypes []v1.NodeAddressType) string {
+	for _, addressType := range addressTypes {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == addressType && addr.Address != "" {
+				return addr.Address
+			}
+		}
+	}
+	return ""
+}
+
+// getNodePortMembers returns one member for every announcable node using
+// the node port of the service port
+func getNodePortMembers(port *v1.ServicePort, nodes []*v1.Node, addressTypes []v1.NodeAddressType) []*zkMember {
+	var members []*zkMember
+	if port.NodePort == 0 {
+		return members
+	}
+	for _, node := range nodes {
+		if !isNodeAnnouncable(node) {
+			continue
+		}
+		addr := getNodeAddr(node, addressTypes)
+		if addr == "" {
+			continue
+		}
+		member := newZKMember()
+		member.id = node.GetName()
+		member.addServiceEndpoint(port.Name, addr, int(port.NodePort))
+		members = append(members, member)
+	}
+	return members
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNode(name string, ready bool, unschedulable bool, addresses ...v1.NodeAddress) *v1.Node {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.NodeSpec{
+			Unschedulable: unschedulable,
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: status},
+			},
+			Addresses: addresses,
+		},
+	}
+}
+
+func TestFuncParseNodeAddressTypes(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		types         string
+		expected      []v1.NodeAddressType
+		expectedError bool
+	}{
+
+		{
+			testName: "default preference",
+			types:    "InternalIP,ExternalIP,Hostname",
+			expected: []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP, v1.NodeHostName},
+		},
+
+		{
+			testName: "with spaces",
+			types:    " ExternalIP , InternalIP",
+			expected: []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP},
+		},
+
+		{
+			testName:      "unknown type",
+			types:         "InternalIP,PublicIP",
+			expectedError: true,
+		},
+
+		{
+			testName:      "empty",
+			types:         "",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		types, err := parseNodeAddressTypes(tc.types)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+		} else {
+			assert.Nil(t, err, tc.testName)
+			assert.Equal(t, tc.expected, types, tc.testName)
+		}
+	}
+}
+
+func TestFuncGetNodePortMembers(t *testing.T) {
+	addressTypes := []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeHostName}
+	nodes := []*v1.Node{
+		newTestNode("ready", true, false,
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+		),
+		newTestNode("hostname-only", true, false,
+			v1.NodeAddress{Type: v1.NodeHostName, Address: "node-2"},
+		),
+		newTestNode("not-ready", false, false,
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"},
+		),
+		newTestNode("cordoned", true, true,
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+		),
+		newTestNode("no-address", true, false,
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.5"},
+		),
+	}
+
+	port := &v1.ServicePort{Name: "http", Port: 80, NodePort: 30080}
+	members := getNodePortMembers(port, nodes, addressTypes)
+
+	got := make(map[string]zkMemberUnite)
+	for _, member := range members {
+		got[member.id] = member.ServiceEndpoint
+	}
+	assert.Equal(t, map[string]zkMemberUnite{
+		"ready":         {Host: "10.0.0.1", Port: 30080},
+		"hostname-only": {Host: "node-2", Port: 30080},
+	}, got)
+
+	assert.Empty(t, getNodePortMembers(&v1.ServicePort{Name: "http", Port: 80}, nodes, addressTypes))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// reservedPaths are never announsed into, whatever the config denies
+var reservedPaths = []string{"/zookeeper"}
+
+// pathPolicy restricts the zookeeper paths services announse into
+//
+//	paths:
+//	  deny: ["/aurora/jobs/infra"]
+//	  maxDepth: 8
+type pathPolicy struct {
+	Deny     []string `json:"deny"`     // denied paths including everything below
+	MaxDepth int      `json:"maxDepth"` // max number of path elements, 0 for no limit
+}
+
+// normalizePath validates a zookeeper path and removes duplicate and
+// trailing slashes. Relative elements and the characters zookeeper does
+// not allow are rejected
+func normalizePath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("path %q is not absolute", p)
+	}
+	for _, r := range p {
+		if !isValidPathRune(r) {
+			return "", fmt.Errorf("path %q contains the invalid character %U", p, r)
+		}
+	}
+	for _, element := range strings.Split(p, "/") {
+		if element == "." || element == ".." {
+			return "", fmt.Errorf("path %q contains the relative element %q", p, element)
+		}
+	}
+	return path.Clean(p), nil
+}
+
+// isValidPathRune returns false for the characters zookeeper does not allow
+// in paths
+func isValidPathRune(r rune) bool {
+	switch {
+	case r <= 0x1f:
+		return false
+	case r >= 0x7f && r <= 0x9f:
+		return false
+	case r >= 0xd800 && r <= 0xf8ff:
+		return false
+	case r >= 0xfff0 && r <= 0xffff:
+		return false
+	}
+	return true
+}
+
+// isPathBelow returns true if p is base or a path below base
+func isPathBelow(p, base string) bool {
+	return p == base || base == "/" || strings.HasPrefix(p, base+"/")
+}
+
+// pathDepth returns the number of elements of a normalized path
+func pathDepth(p string) int {
+	if p == "/" {
+		return 0
+	}
+	return strings.Count(p, "/")
+}
+
+// validate normalizes the deny list of the policy
+func (p *pathPolicy) validate() error {
+	if p.MaxDepth < 0 {
+		return fmt.Errorf("negative maxDepth %v", p.MaxDepth)
+	}
+	for i, deny := range p.Deny {
+		normalized, err := normalizePath(deny)
+		if err != nil {
+			return fmt.Errorf("deny: %v", err.Error())
+		}
+		p.Deny[i] = normalized
+	}
+	return nil
+}
+
+// check returns the normalized member path of a service, or the reason the
+// path is rejected
+func (p *pathPolicy) check(memberPath string) (string, error) {
+	normalized, err := normalizePath(memberPath)
+	if err != nil {
+		return "", err
+	}
+	if normalized == "/" {
+		return "", fmt.Errorf("members can not be announsed into the root path")
+	}
+	for _, reserved := range reservedPaths {
+		if isPathBelow(normalized, reserved) {
+			return "", fmt.Errorf("path %v is reserved by zookeeper", normalized)
+		}
+	}
+	for _, deny := range p.Deny {
+		if isPathBelow(normalized, deny) {
+			return "", fmt.Errorf("path %v is denied by %v", normalized, deny)
+		}
+	}
+	if p.MaxDepth != 0 && pathDepth(normalized) > p.MaxDepth {
+		return "", fmt.Errorf("path %v is deeper than %v elements", normalized, p.MaxDepth)
+	}
+	return normalized, nil
+}
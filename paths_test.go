@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathPolicyCheck(t *testing.T) {
+	policy := pathPolicy{
+		Deny:     []string{"/aurora/jobs/infra"},
+		MaxDepth: 5,
+	}
+
+	testCases := []struct {
+		testName      string
+		path          string
+		expected      string
+		expectedError bool
+	}{
+		{testName: "valid path", path: "/aurora/jobs/role/prod/api", expected: "/aurora/jobs/role/prod/api"},
+		{testName: "trailing slash", path: "/aurora/jobs/api/", expected: "/aurora/jobs/api"},
+		{testName: "duplicate slashes", path: "/aurora//jobs/api", expected: "/aurora/jobs/api"},
+		{testName: "similar to denied path", path: "/aurora/jobs/infra-api", expected: "/aurora/jobs/infra-api"},
+		{testName: "empty path", path: "", expectedError: true},
+		{testName: "relative path", path: "aurora/jobs/api", expectedError: true},
+		{testName: "root path", path: "/", expectedError: true},
+		{testName: "root path with slashes", path: "//", expectedError: true},
+		{testName: "relative element", path: "/aurora/../zookeeper", expectedError: true},
+		{testName: "current element", path: "/aurora/./jobs", expectedError: true},
+		{testName: "control character", path: "/aurora/jobs\n/api", expectedError: true},
+		{testName: "null character", path: "/aurora/jobs\x00", expectedError: true},
+		{testName: "reserved path", path: "/zookeeper", expectedError: true},
+		{testName: "below reserved path", path: "/zookeeper/quota", expectedError: true},
+		{testName: "denied path", path: "/aurora/jobs/infra", expectedError: true},
+		{testName: "below denied path", path: "/aurora/jobs/infra/prod/api", expectedError: true},
+		{testName: "too deep", path: "/aurora/jobs/role/prod/api/extra", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		result, err := policy.check(tc.path)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+			continue
+		}
+		assert.Nil(t, err, tc.testName)
+		assert.Equal(t, tc.expected, result, tc.testName)
+	}
+}
+
+func TestPathPolicyValidate(t *testing.T) {
+	policy := pathPolicy{Deny: []string{"/aurora/jobs/infra/"}}
+	assert.Nil(t, policy.validate())
+	assert.Equal(t, []string{"/aurora/jobs/infra"}, policy.Deny)
+
+	policy = pathPolicy{Deny: []string{"aurora"}}
+	assert.NotNil(t, policy.validate())
+
+	policy = pathPolicy{MaxDepth: -1}
+	assert.NotNil(t, policy.validate())
+}
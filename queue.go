@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// workQueue is a keyed work queue in the style of the client-go workqueue.
+// A key is only queued once, a key added while it is processed is queued
+// again when Done is called, and failed keys are added back with exponential
+// backoff
+type workQueue struct {
+	cond *sync.Cond
+
+	queue      []string
+	dirty      map[string]bool
+	processing map[string]bool
+	failures   map[string]int
+
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	shuttingDown bool
+}
+
+func newWorkQueue(baseDelay, maxDelay time.Duration) *workQueue {
+	q := workQueue{
+		cond:       sync.NewCond(&sync.Mutex{}),
+		dirty:      make(map[string]bool),
+		processing: make(map[string]bool),
+		failures:   make(map[string]int),
+		baseDelay:  baseDelay,
+		maxDelay:   maxDelay,
+	}
+	return &q
+}
+
+// Add queues the key unless it is already waiting to be processed
+func (q *workQueue) Add(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown || q.dirty[key] {
+		return
+	}
+	q.dirty[key] = true
+	if q.processing[key] {
+		return
+	}
+	q.queue = append(q.queue, key)
+	q.cond.Signal()
+}
+
+// AddRateLimited queues the key after the backoff for its number of failures
+func (q *workQueue) AddRateLimited(key string) {
+	q.cond.L.Lock()
+	delay := q.backoff(q.failures[key])
+	q.failures[key]++
+	q.cond.L.Unlock()
+
+	time.AfterFunc(delay, func() {
+		q.Add(key)
+	})
+}
+
+// backoff returns the delay after the given number of failures
+func (q *workQueue) backoff(failures int) time.Duration {
+	delay := float64(q.baseDelay) * math.Pow(2, float64(failures))
+	if delay > float64(q.maxDelay) {
+		return q.maxDelay
+	}
+	return time.Duration(delay)
+}
+
+// Forget resets the failures of the key
+func (q *workQueue) Forget(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.failures, key)
+}
+
+// NumRequeues returns the number of failures of the key
+func (q *workQueue) NumRequeues(key string) int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.failures[key]
+}
+
+// Len returns the number of keys waiting to be processed
+func (q *workQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.queue)
+}
+
+// Get blocks until a key can be processed. Done must be called with the
+// key once it is processed
+func (q *workQueue) Get() (string, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return "", true
+	}
+
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	q.processing[key] = true
+	delete(q.dirty, key)
+	return key, false
+}
+
+// Done marks the key as processed, queueing it again if it was added
+// while it was processed
+func (q *workQueue) Done(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, key)
+	if q.dirty[key] {
+		q.queue = append(q.queue, key)
+		q.cond.Signal()
+	}
+}
+
+// ShutDown makes Get return once the queue is empty and ignores new keys
+func (q *workQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
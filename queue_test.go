@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkQueueDeduplicates(t *testing.T) {
+	q := newWorkQueue(time.Millisecond, time.Second)
+	q.Add("default/foo")
+	q.Add("default/bar")
+	q.Add("default/foo")
+	assert.Equal(t, 2, q.Len())
+
+	key, quit := q.Get()
+	assert.False(t, quit)
+	assert.Equal(t, "default/foo", key)
+
+	// added again while processing, queued when done
+	q.Add("default/foo")
+	assert.Equal(t, 1, q.Len())
+	q.Done("default/foo")
+	assert.Equal(t, 2, q.Len())
+
+	key, _ = q.Get()
+	assert.Equal(t, "default/bar", key)
+	q.Done(key)
+	key, _ = q.Get()
+	assert.Equal(t, "default/foo", key)
+	q.Done(key)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestWorkQueueRateLimited(t *testing.T) {
+	q := newWorkQueue(time.Millisecond, 4*time.Millisecond)
+	assert.Equal(t, time.Millisecond, q.backoff(0))
+	assert.Equal(t, 2*time.Millisecond, q.backoff(1))
+	assert.Equal(t, 4*time.Millisecond, q.backoff(2))
+	assert.Equal(t, 4*time.Millisecond, q.backoff(10))
+
+	q.AddRateLimited("default/foo")
+	q.AddRateLimited("default/foo")
+	assert.Equal(t, 2, q.NumRequeues("default/foo"))
+
+	key, quit := q.Get()
+	assert.False(t, quit)
+	assert.Equal(t, "default/foo", key)
+	q.Done(key)
+
+	q.Forget("default/foo")
+	assert.Equal(t, 0, q.NumRequeues("default/foo"))
+}
+
+func TestWorkQueueShutDown(t *testing.T) {
+	q := newWorkQueue(time.Millisecond, time.Second)
+	q.Add("default/foo")
+	q.ShutDown()
+	q.Add("default/bar")
+
+	key, quit := q.Get()
+	assert.False(t, quit)
+	assert.Equal(t, "default/foo", key)
+
+	_, quit = q.Get()
+	assert.True(t, quit)
+}
@@ -2,18 +2,23 @@ package main
 
 import (
 	"fmt"
-	"time"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
 )
 
 const (
-	eventCreate               = "create"
-	eventUpdate               = "update"
-	eventDelete               = "delete"
-	serviceAnnotationPath     = "service.announser/zookeeper-path"
-	serviceAnnotationPortName = "service.announser/portname"
+	eventUpdate                = "update"
+	eventDelete                = "delete"
+	serviceAnnotationPath      = "service.announser/zookeeper-path"
+	serviceAnnotationPortName  = "service.announser/portname"
+	serviceAnnotationIngress   = "service.announser/all-ingress"
+	serviceAnnotationACL       = "service.announser/acl-policy"
+	serviceAnnotationEnsembles = "service.announser/ensembles"
 )
 
 func checkRequiredServiceFieldsExists(service *v1.Service) error {
@@ -24,8 +29,12 @@ func checkRequiredServiceFieldsExists(service *v1.Service) error {
 	if _, ok := annotations[serviceAnnotationPath]; !ok {
 		return fmt.Errorf("missing annotation %v", serviceAnnotationPath)
 	}
-	if service.Spec.Type != "LoadBalancer" {
-		return fmt.Errorf("only type LoadBalancer supported. %v not supported yet", service.Spec.Type)
+	switch service.Spec.Type {
+	case v1.ServiceTypeLoadBalancer:
+	case v1.ServiceTypeClusterIP:
+	case v1.ServiceTypeNodePort:
+	default:
+		return fmt.Errorf("only type LoadBalancer, ClusterIP and NodePort supported. %v not supported yet", service.Spec.Type)
 	}
 	return nil
 }
@@ -39,7 +48,14 @@ func getServicePortByName(name string, service *v1.Service) *v1.ServicePort {
 	return nil
 }
 
+func isHeadlessService(service *v1.Service) bool {
+	return service.Spec.Type == v1.ServiceTypeClusterIP && service.Spec.ClusterIP == v1.ClusterIPNone
+}
+
 func getServiceAddr(service *v1.Service) string {
+	if service.Spec.Type == v1.ServiceTypeClusterIP {
+		return service.Spec.ClusterIP
+	}
 	for _, val := range service.Status.LoadBalancer.Ingress {
 		if val.Hostname != "" {
 			return val.Hostname
@@ -50,101 +66,309 @@ func getServiceAddr(service *v1.Service) string {
 	return ""
 }
 
-func newUpdaterEvent(eventType string, service *v1.Service) (*UpdaterEvent, error) {
-	err := checkRequiredServiceFieldsExists(service)
-	if err != nil {
-		return nil, fmt.Errorf("error service %v, err: %v", service.GetName(), err.Error())
+// getServiceAddrs returns every LoadBalancer ingress hostname or ip
+func getServiceAddrs(service *v1.Service) []string {
+	var addrs []string
+	for _, val := range service.Status.LoadBalancer.Ingress {
+		if val.Hostname != "" {
+			addrs = append(addrs, val.Hostname)
+		} else if val.IP != "" {
+			addrs = append(addrs, val.IP)
+		}
 	}
+	return addrs
+}
 
-	annotations := service.GetAnnotations()
-	member := newZKMember()
-	member.path = annotations[serviceAnnotationPath]
-	member.name = service.GetName()
-	member.prefix = service.GetResourceVersion()
-
-	portname := annotations[serviceAnnotationPortName]
-	port := getServicePortByName(portname, service)
-	if port == nil {
-		return nil, fmt.Errorf("service named missing port")
+// announceAllIngress returns true when the service opted in to one member
+// per LoadBalancer ingress entry
+func announceAllIngress(service *v1.Service) (bool, error) {
+	val, ok := service.GetAnnotations()[serviceAnnotationIngress]
+	if !ok || service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false, nil
 	}
-	serviceAddr := getServiceAddr(service)
-	if serviceAddr == "" {
-		return nil, fmt.Errorf("missing LoadBalancer hostname or ip will retry")
+	all, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid annotation %v: %v", serviceAnnotationIngress, err.Error())
 	}
-	member.addServiceEndpoint(
-		portname,
-		serviceAddr,
-		int(port.Port),
-	)
+	return all, nil
+}
 
-	event := UpdaterEvent{
-		eventType:  eventType,
-		member:     member,
-		retryCount: 5,
-		retryWait:  5 * time.Second,
+// getEndpointsMembers returns one member for every ready pod address
+// serving the named port
+func getEndpointsMembers(portname string, endpoints *v1.Endpoints) []*zkMember {
+	var members []*zkMember
+	if endpoints == nil {
+		return members
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != portname {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				member := newZKMember()
+				member.id = addr.IP
+				member.addServiceEndpoint(portname, addr.IP, int(port.Port))
+				members = append(members, member)
+			}
+		}
 	}
-	return &event, nil
+	return members
 }
 
-// UpdaterEvent create/update/delete of zkmember
+// UpdaterEvent update/delete of the zkmembers of a service
 type UpdaterEvent struct {
-	eventType  string // create/update/delete
-	member     *zkMember
-	retryCount int
-	retryWait  time.Duration
+	eventType  string // update/delete
+	name       string // service key namespace/name
+	members    []*zkMember
+	owner      *memberOwner // owner of persistent members, nil for ephemeral members
+	path       string       // zookeeper path of the persistent members
+	ownedPaths []string     // paths recorded on the service to hold its persistent members
+	ensembles  []string     // ensembles the members are announsed to
+}
+
+// selects returns true if the members are announsed to the ensemble
+func (e *UpdaterEvent) selects(ensemble string) bool {
+	if e.eventType != eventUpdate {
+		return false
+	}
+	for _, name := range e.ensembles {
+		if name == ensemble {
+			return true
+		}
+	}
+	return false
 }
 
-func newUpdater(zookeeperAddr string) *Updater {
+func newUpdater(config zooConfig, ensembles map[string]*ensembleConfig) *Updater {
 	updater := Updater{
-		events:        make(chan UpdaterEvent),
-		zookeeperAddr: zookeeperAddr,
+		zookeepers: make(map[string]*Zoo),
+	}
+	updater.addEnsemble(defaultEnsemble, config)
+	for name, ensemble := range ensembles {
+		updater.addEnsemble(name, ensemble.zooConfig(config))
 	}
-	updater.zookeeper.Init()
 	return &updater
 }
 
-// Updater event worker
+// Updater applies events to the zookeeper ensembles
 type Updater struct {
-	events        chan UpdaterEvent
-	zookeeper     Zoo
-	zookeeperAddr string
+	mu         sync.Mutex
+	zookeepers map[string]*Zoo // keyed by ensemble name
+	resync     func()          // queues all services
+	tampered   func(key, message string)
+}
+
+func (u *Updater) addEnsemble(name string, config zooConfig) {
+	zoo := Zoo{}
+	zoo.Init(config)
+	u.zookeepers[name] = &zoo
 }
 
-// Run starts to wait for events and executes them
+// ensembles returns the sorted names of all ensembles
+func (u *Updater) ensembles() []string {
+	var names []string
+	for name := range u.zookeepers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Connect to all zookeeper ensembles
+func (u *Updater) Connect() error {
+	for _, name := range u.ensembles() {
+		err := u.zookeepers[name].Conn()
+		if err != nil {
+			return fmt.Errorf("ensemble %v: %v", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// deleteMembers deletes the members of the service in the ensemble, and
+// the persistent members written before a restart when owned is set
+func (u *Updater) deleteMembers(zoo *Zoo, event *UpdaterEvent, owned bool) error {
+	err := zoo.DeleteServiceMembers(event.name)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return nil
+	}
+	return u.deleteOwnedMembers(zoo, event, "")
+}
+
+// deleteOwnedMembers deletes the persistent members of the service in the
+// path of the event and the recorded paths, except the path kept
+func (u *Updater) deleteOwnedMembers(zoo *Zoo, event *UpdaterEvent, kept string) error {
+	if event.owner == nil {
+		return nil
+	}
+	for _, memberPath := range withMemberPath(event.ownedPaths, event.path) {
+		if memberPath == kept {
+			continue
+		}
+		deleted, err := zoo.DeleteOwnedMembers(event.owner, memberPath)
+		if err != nil {
+			return fmt.Errorf("failed to delete persistent members: %v", err.Error())
+		}
+		if deleted != 0 {
+			log.Infof("deleted %v persistent members of service %v in %v", deleted, event.name, memberPath)
+		}
+	}
+	return nil
+}
+
+// Process applies the event to zookeeper. The members are deleted from
+// the ensembles the service no longer selects
+func (u *Updater) Process(event *UpdaterEvent) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	log.Debugf("process event: %v service: %v", event.eventType, event.name)
+	var errs []string
+	for _, name := range u.ensembles() {
+		zoo := u.zookeepers[name]
+		var err error
+		if event.selects(name) {
+			err = zoo.ProcessServiceMembers(event.name, event.members)
+			if err == nil {
+				err = u.deleteOwnedMembers(zoo, event, event.path)
+			}
+		} else {
+			err = u.deleteMembers(zoo, event, event.eventType == eventDelete)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("ensemble %v: %v", name, err.Error()))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if event.eventType == eventUpdate {
+		return fmt.Errorf("failed to update members: %v %v", event.name, strings.Join(errs, ", "))
+	}
+	return fmt.Errorf("failed to delete members: %v %v", event.name, strings.Join(errs, ", "))
+}
+
+// Reconcile applies the update event and deletes the members an earlier
+// session of the announser left behind for the service
+func (u *Updater) Reconcile(event *UpdaterEvent) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	log.Debugf("reconcile service: %v", event.name)
+	var errs []string
+	for _, name := range u.ensembles() {
+		zoo := u.zookeepers[name]
+		if !event.selects(name) {
+			if err := u.deleteMembers(zoo, event, true); err != nil {
+				errs = append(errs, fmt.Sprintf("ensemble %v: %v", name, err.Error()))
+			}
+			continue
+		}
+		deleted, err := zoo.ReconcileServiceMembers(event.name, event.members)
+		if err == nil {
+			err = u.deleteOwnedMembers(zoo, event, event.path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("ensemble %v: %v", name, err.Error()))
+		}
+		if deleted != 0 {
+			log.Infof("deleted %v stale members of service %v in ensemble %v", deleted, event.name, name)
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to reconcile members: %v %v", event.name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// CheckDrift finds the members of the service that drifted in zookeeper
+// without writing to zookeeper, the drift is fixed when the service is
+// processed next. It returns the number of drifted members
+func (u *Updater) CheckDrift(event *UpdaterEvent) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	log.Debugf("check service: %v", event.name)
+	drifted := 0
+	var errs []string
+	for _, name := range u.ensembles() {
+		zoo := u.zookeepers[name]
+		if !event.selects(name) {
+			if zoo.active.keyIn(event.name) {
+				log.Infof("check service: %v no longer announsed to ensemble %v", event.name, name)
+				drifted += len(zoo.active.ids(event.name))
+			}
+			continue
+		}
+		n, err := zoo.CheckServiceMembers(event.name, event.members)
+		drifted += n
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("ensemble %v: %v", name, err.Error()))
+		}
+	}
+	if len(errs) != 0 {
+		return drifted, fmt.Errorf("failed to check members: %v %v", event.name, strings.Join(errs, ", "))
+	}
+	return drifted, nil
+}
+
+// ActiveServices returns the keys of all services with members in any ensemble
+func (u *Updater) ActiveServices() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	seen := make(map[string]bool)
+	var keys []string
+	for _, zoo := range u.zookeepers {
+		for _, key := range zoo.ActiveServices() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Run watches the sessions and members of every ensemble until stopped
 func (u *Updater) Run(stopCh chan struct{}) {
 	log.Info("Starting Updater")
-	err := u.zookeeper.Conn(u.zookeeperAddr)
-	if err != nil {
-		log.Errorf("failed to connect to zookeeper: %v", err.Error())
-		close(stopCh)
+	for _, name := range u.ensembles() {
+		go u.runEnsemble(name, u.zookeepers[name], stopCh)
+	}
+	<-stopCh
+	log.Info("stopping updater runner")
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, name := range u.ensembles() {
+		u.zookeepers[name].Close()
 	}
+}
 
+// runEnsemble waits for new zookeeper sessions of the ensemble and queues
+// all services again, and checks the members whose watch fired
+func (u *Updater) runEnsemble(name string, zoo *Zoo, stopCh chan struct{}) {
 	for {
 		select {
-		case event := <-u.events:
-			log.Debugf("process event: %v service: %v", event.eventType, event.member.name)
-			switch event.eventType {
-			case eventCreate:
-				log.Debugf("create event")
-				err := u.zookeeper.AddServiceMember(event.member)
-				if err != nil {
-					log.Errorf("failed to create member: %v %v", event.member.name, err.Error())
-				}
-			case eventUpdate:
-				log.Debugf("update event not implemented")
-				err := u.zookeeper.AddServiceMember(event.member)
-				if err != nil {
-					log.Errorf("failed to update member: %v %v", event.member.name, err.Error())
-				}
-			case eventDelete:
-				log.Debugf("delete event")
-				err := u.zookeeper.DeleteServiceMember(event.member)
-				if err != nil {
-					log.Errorf("failed to delete member: %v %v", event.member.name, err.Error())
-				}
+		case <-zoo.newSession:
+			log.Infof("new zookeeper session of ensemble %v, adding all members again", name)
+			u.mu.Lock()
+			zoo.ResetActive()
+			u.mu.Unlock()
+			if u.resync != nil {
+				u.resync()
+			}
+		case event := <-zoo.memberEvents:
+			u.mu.Lock()
+			key, message, tampered := zoo.HandleMemberEvent(event)
+			u.mu.Unlock()
+			if tampered && u.tampered != nil {
+				u.tampered(key, message)
 			}
-		case _ = <-stopCh:
-			log.Info("stopping updater runner")
+		case <-stopCh:
 			return
 		}
 	}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -78,7 +80,8 @@ func TestFuncCheckRequiredServiceFieldsExists(t *testing.T) {
 					},
 				},
 				Spec: v1.ServiceSpec{
-					Type: "ClusterIP",
+					Type:      "ClusterIP",
+					ClusterIP: "10.0.0.10",
 					Ports: []v1.ServicePort{
 						{
 							Name: "http",
@@ -87,6 +90,71 @@ func TestFuncCheckRequiredServiceFieldsExists(t *testing.T) {
 					},
 				},
 			},
+			expectedError: false,
+		},
+
+		{
+			testName: "type ClusterIP headless",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "nginx",
+					Annotations: map[string]string{
+						serviceAnnotationPath:     "/foo/bar",
+						serviceAnnotationPortName: "http",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type:      "ClusterIP",
+					ClusterIP: "None",
+					Ports: []v1.ServicePort{
+						{
+							Name: "http",
+							Port: 80,
+						},
+					},
+				},
+			},
+			expectedError: false,
+		},
+
+		{
+			testName: "type NodePort",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "nginx",
+					Annotations: map[string]string{
+						serviceAnnotationPath:     "/foo/bar",
+						serviceAnnotationPortName: "http",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type: "NodePort",
+					Ports: []v1.ServicePort{
+						{
+							Name: "http",
+							Port: 80,
+						},
+					},
+				},
+			},
+			expectedError: false,
+		},
+
+		{
+			testName: "type ExternalName",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "nginx",
+					Annotations: map[string]string{
+						serviceAnnotationPath:     "/foo/bar",
+						serviceAnnotationPortName: "http",
+					},
+				},
+				Spec: v1.ServiceSpec{
+					Type:         "ExternalName",
+					ExternalName: "nginx.example.com",
+				},
+			},
 			expectedError: true,
 		},
 	}
@@ -165,3 +233,293 @@ func TestFuncGetServicePortByName(t *testing.T) {
 		}
 	}
 }
+
+func TestFuncGetServiceAddr(t *testing.T) {
+	testCases := []struct {
+		testName string
+		service  *v1.Service
+		expected string
+	}{
+
+		{
+			testName: "type LoadBalancer with hostname",
+			service: &v1.Service{
+				Spec: v1.ServiceSpec{
+					Type:      "LoadBalancer",
+					ClusterIP: "10.0.0.10",
+				},
+				Status: v1.ServiceStatus{
+					LoadBalancer: v1.LoadBalancerStatus{
+						Ingress: []v1.LoadBalancerIngress{
+							{Hostname: "elb.example.com"},
+						},
+					},
+				},
+			},
+			expected: "elb.example.com",
+		},
+
+		{
+			testName: "type LoadBalancer without ingress",
+			service: &v1.Service{
+				Spec: v1.ServiceSpec{
+					Type:      "LoadBalancer",
+					ClusterIP: "10.0.0.10",
+				},
+			},
+			expected: "",
+		},
+
+		{
+			testName: "type ClusterIP",
+			service: &v1.Service{
+				Spec: v1.ServiceSpec{
+					Type:      "ClusterIP",
+					ClusterIP: "10.0.0.10",
+				},
+			},
+			expected: "10.0.0.10",
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, getServiceAddr(tc.service), tc.testName)
+	}
+}
+
+func TestFuncGetEndpointsMembers(t *testing.T) {
+	testCases := []struct {
+		testName  string
+		portname  string
+		endpoints *v1.Endpoints
+		expected  map[string]int
+	}{
+
+		{
+			testName:  "without endpoints",
+			portname:  "http",
+			endpoints: nil,
+			expected:  map[string]int{},
+		},
+
+		{
+			testName: "with ready and not ready addresses",
+			portname: "http",
+			endpoints: &v1.Endpoints{
+				Subsets: []v1.EndpointSubset{
+					{
+						Addresses: []v1.EndpointAddress{
+							{IP: "10.1.0.1"},
+							{IP: "10.1.0.2"},
+						},
+						NotReadyAddresses: []v1.EndpointAddress{
+							{IP: "10.1.0.3"},
+						},
+						Ports: []v1.EndpointPort{
+							{Name: "http", Port: 8080},
+							{Name: "admin", Port: 9990},
+						},
+					},
+				},
+			},
+			expected: map[string]int{
+				"10.1.0.1": 8080,
+				"10.1.0.2": 8080,
+			},
+		},
+
+		{
+			testName: "with missing port",
+			portname: "http",
+			endpoints: &v1.Endpoints{
+				Subsets: []v1.EndpointSubset{
+					{
+						Addresses: []v1.EndpointAddress{
+							{IP: "10.1.0.1"},
+						},
+						Ports: []v1.EndpointPort{
+							{Name: "admin", Port: 9990},
+						},
+					},
+				},
+			},
+			expected: map[string]int{},
+		},
+	}
+
+	for _, tc := range testCases {
+		members := getEndpointsMembers(tc.portname, tc.endpoints)
+		got := make(map[string]int)
+		for _, member := range members {
+			assert.Equal(t, member.id, member.ServiceEndpoint.Host, tc.testName)
+			got[member.ServiceEndpoint.Host] = member.ServiceEndpoint.Port
+		}
+		assert.Equal(t, tc.expected, got, tc.testName)
+	}
+}
+
+func TestFuncGetServiceAddrs(t *testing.T) {
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Type: "LoadBalancer",
+		},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{
+					{IP: "192.168.1.10"},
+					{Hostname: "nlb.example.com"},
+					{},
+					{IP: "192.168.1.11"},
+				},
+			},
+		},
+	}
+	assert.Equal(t, []string{"192.168.1.10", "nlb.example.com", "192.168.1.11"}, getServiceAddrs(service))
+	assert.Empty(t, getServiceAddrs(&v1.Service{}))
+}
+
+func TestFuncAnnounceAllIngress(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		service       *v1.Service
+		expected      bool
+		expectedError bool
+	}{
+
+		{
+			testName: "without annotation",
+			service: &v1.Service{
+				Spec: v1.ServiceSpec{Type: "LoadBalancer"},
+			},
+			expected: false,
+		},
+
+		{
+			testName: "with annotation",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{serviceAnnotationIngress: "true"},
+				},
+				Spec: v1.ServiceSpec{Type: "LoadBalancer"},
+			},
+			expected: true,
+		},
+
+		{
+			testName: "with annotation on ClusterIP",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{serviceAnnotationIngress: "true"},
+				},
+				Spec: v1.ServiceSpec{Type: "ClusterIP"},
+			},
+			expected: false,
+		},
+
+		{
+			testName: "with invalid annotation",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{serviceAnnotationIngress: "yes please"},
+				},
+				Spec: v1.ServiceSpec{Type: "LoadBalancer"},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		all, err := announceAllIngress(tc.service)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+		} else {
+			assert.Nil(t, err, tc.testName)
+			assert.Equal(t, tc.expected, all, tc.testName)
+		}
+	}
+}
+
+func TestRunEnsembleNewSession(t *testing.T) {
+	z, conn := newTestZoo(zooConfig{})
+	resynced := make(chan struct{}, 1)
+	u := Updater{
+		zookeepers: map[string]*Zoo{defaultEnsemble: z},
+		resync:     func() { resynced <- struct{}{} },
+	}
+	z.active.add("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+
+	events := make(chan zk.Event)
+	defer close(events)
+	go z.watchSession(events)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go u.runEnsemble(defaultEnsemble, z, stopCh)
+
+	// reconnecting within the session keeps the members
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateDisconnected}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateDisconnected}
+	select {
+	case <-resynced:
+		t.Fatal("resync without a new session")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn.session = 2
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	select {
+	case <-resynced:
+	case <-time.After(time.Second):
+		t.Fatal("no resync after the session expired")
+	}
+	u.mu.Lock()
+	assert.False(t, z.active.keyIn("dev/api"))
+	u.mu.Unlock()
+}
+
+func TestProcessOwnedPaths(t *testing.T) {
+	owner := &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-1"}
+	other := &memberOwner{Cluster: "prod", Namespace: "dev", Name: "web", UID: "uid-2"}
+	newData := func(owner *memberOwner, host string) []byte {
+		member := newTestMember("dev/api", host, "", host, 80)
+		member.Owner = owner
+		data, err := member.marshalJSON()
+		assert.Nil(t, err)
+		return data
+	}
+	newZoo := func() (*Updater, *fakeConn) {
+		z, conn := newTestZoo(zooConfig{persistent: true})
+		conn.create("/aurora/jobs/api/member_0000000001", newData(owner, "10.0.0.1"), 0)
+		conn.create("/aurora/jobs/old/member_0000000001", newData(owner, "10.0.0.2"), 0)
+		conn.create("/aurora/jobs/old/member_0000000002", newData(other, "10.0.0.3"), 0)
+		return &Updater{zookeepers: map[string]*Zoo{defaultEnsemble: z}}, conn
+	}
+
+	// a delete without the path annotation deletes the members in the recorded paths
+	u, conn := newZoo()
+	err := u.Process(&UpdaterEvent{
+		eventType:  eventDelete,
+		name:       "dev/api",
+		owner:      owner,
+		ownedPaths: []string{"/aurora/jobs/api", "/aurora/jobs/old"},
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, conn.children("/aurora/jobs/api"))
+	assert.Equal(t, []string{"/aurora/jobs/old/member_0000000002"}, conn.children("/aurora/jobs/old"))
+
+	// an update deletes the members in the recorded paths the service moved from
+	u, conn = newZoo()
+	err = u.Process(&UpdaterEvent{
+		eventType:  eventUpdate,
+		name:       "dev/api",
+		owner:      owner,
+		path:       "/aurora/jobs/api",
+		ownedPaths: []string{"/aurora/jobs/old"},
+		ensembles:  []string{defaultEnsemble},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/aurora/jobs/api/member_0000000001"}, conn.children("/aurora/jobs/api"))
+	assert.Equal(t, []string{"/aurora/jobs/old/member_0000000002"}, conn.children("/aurora/jobs/old"))
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"reflect"
+
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 //{
@@ -43,14 +47,45 @@ type zkMemberUnite struct {
 type Endpoints map[string]zkMemberUnite
 
 type zkMember struct {
-	name   string
+	name   string // service key namespace/name
+	uid    string // service uid
+	id     string // identifies the member within the service
 	path   string // zookeeper path
 	prefix string
 
+	acl       []zk.ACL // acl of the member znode, nil for the default acl
+	parentACL []zk.ACL // acl of created parent znodes, nil for the default acl
+
 	Status              string        `json:"status"` // set to ALIVE
 	AdditionalEndpoints Endpoints     `json:"additionalEndpoints"`
 	ServiceEndpoint     zkMemberUnite `json:"serviceEndpoint"`
 	Shard               int           `json:"shard"`
+	Owner               *memberOwner  `json:"announser,omitempty"` // set for persistent members
+}
+
+// memberOwner records the service owning a persistent member in the member
+// data, finagle and aurora ignore the unknown key
+type memberOwner struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+	ID        string `json:"id"`
+}
+
+// key returns the namespace/name key of the owning service
+func (o *memberOwner) key() string {
+	return o.Namespace + "/" + o.Name
+}
+
+// sameService returns true if both owners are the same service of the same
+// cluster, the member id is not compared
+func (o *memberOwner) sameService(other *memberOwner) bool {
+	if o == nil || other == nil {
+		return false
+	}
+	return o.Cluster == other.Cluster && o.Namespace == other.Namespace &&
+		o.Name == other.Name && o.UID == other.UID
 }
 
 func (z *zkMember) addAdditionalEndpoints(name, addr string, port int) {
@@ -79,6 +114,65 @@ func (z *zkMember) unmarshalJSON(bytebuff []byte) (*zkMember, error) {
 	return member, nil
 }
 
+// sameData returns true if both members marshal to the same znode data
+func (z *zkMember) sameData(other *zkMember) bool {
+	if other == nil {
+		return false
+	}
+	data, err := z.marshalJSON()
+	if err != nil {
+		return false
+	}
+	otherData, err := other.marshalJSON()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, otherData)
+}
+
+// sameACL returns true if both members use the same acl
+func (z *zkMember) sameACL(other *zkMember) bool {
+	if other == nil {
+		return false
+	}
+	return reflect.DeepEqual(z.acl, other.acl)
+}
+
+// isStaleMember returns true if an existing member announses the same
+// endpoints as one of the members, it was then written by an earlier session.
+// Members of other writers on the same host use other ports and are kept
+func isStaleMember(existing *zkMember, members []*zkMember) bool {
+	if existing.ServiceEndpoint.Host == "" {
+		return false
+	}
+	for _, member := range members {
+		if existing.ServiceEndpoint == member.ServiceEndpoint &&
+			reflect.DeepEqual(existing.AdditionalEndpoints, member.AdditionalEndpoints) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedMember returns if the existing persistent member was written for the
+// service of the members, and the member with the same uid and id it can be
+// adopted as. The member is nil when the existing member is no longer wanted
+func ownedMember(existing *zkMember, members []*zkMember) (member *zkMember, owned bool) {
+	if existing.Owner == nil {
+		return nil, false
+	}
+	for _, m := range members {
+		if m.Owner == nil || m.Owner.Cluster != existing.Owner.Cluster || m.Owner.key() != existing.Owner.key() {
+			continue
+		}
+		owned = true
+		if m.Owner.sameService(existing.Owner) && m.id == existing.Owner.ID {
+			return m, true
+		}
+	}
+	return nil, owned
+}
+
 func (z *zkMember) anyEndpoints() bool {
 	if len(z.AdditionalEndpoints) >= 1 && (z.ServiceEndpoint.Host != "" && z.ServiceEndpoint.Port != 0) {
 		return true
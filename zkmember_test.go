@@ -67,3 +67,116 @@ func TestHaveEndpoints(t *testing.T) {
 			fmt.Sprintf("%#v", tc.member))
 	}
 }
+
+func TestSameData(t *testing.T) {
+	member := newZKMember()
+	member.name = "foo"
+	member.addServiceEndpoint("http", "localhost", 123)
+
+	same := newZKMember()
+	same.name = "foo"
+	same.path = "/other/path"
+	same.addServiceEndpoint("http", "localhost", 123)
+
+	changed := newZKMember()
+	changed.name = "foo"
+	changed.addServiceEndpoint("http", "localhost", 124)
+
+	assert.True(t, member.sameData(same))
+	assert.False(t, member.sameData(changed))
+	assert.False(t, member.sameData(nil))
+}
+
+func TestIsStaleMember(t *testing.T) {
+	member := newZKMember()
+	member.addServiceEndpoint("http", "10.0.0.1", 80)
+	members := []*zkMember{member}
+
+	sameEndpoints := newZKMember()
+	sameEndpoints.addServiceEndpoint("http", "10.0.0.1", 80)
+
+	otherPort := newZKMember()
+	otherPort.addServiceEndpoint("http", "10.0.0.1", 8080)
+
+	otherAdditional := newZKMember()
+	otherAdditional.addServiceEndpoint("http", "10.0.0.1", 80)
+	otherAdditional.addAdditionalEndpoints("health", "10.0.0.1", 8081)
+
+	otherHost := newZKMember()
+	otherHost.addServiceEndpoint("http", "10.0.0.2", 80)
+
+	assert.True(t, isStaleMember(sameEndpoints, members))
+	assert.False(t, isStaleMember(otherPort, members))
+	assert.False(t, isStaleMember(otherAdditional, members))
+	assert.False(t, isStaleMember(otherHost, members))
+	assert.False(t, isStaleMember(newZKMember(), members))
+	assert.False(t, isStaleMember(sameEndpoints, nil))
+}
+
+func TestOwnedMember(t *testing.T) {
+	newMember := func(id string) *zkMember {
+		member := newZKMember()
+		member.id = id
+		member.Owner = &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-1", ID: id}
+		return member
+	}
+	members := []*zkMember{newMember("10.0.0.1"), newMember("10.0.0.2")}
+
+	testCases := []struct {
+		testName string
+		owner    *memberOwner
+		member   *zkMember
+		owned    bool
+	}{
+		{
+			testName: "wanted member",
+			owner:    &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-1", ID: "10.0.0.2"},
+			member:   members[1],
+			owned:    true,
+		},
+		{
+			testName: "member id no longer wanted",
+			owner:    &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-1", ID: "10.0.0.3"},
+			owned:    true,
+		},
+		{
+			testName: "member of the deleted service with the same name",
+			owner:    &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-0", ID: "10.0.0.1"},
+			owned:    true,
+		},
+		{
+			testName: "member of another service",
+			owner:    &memberOwner{Cluster: "prod", Namespace: "prod", Name: "api", UID: "uid-2", ID: "10.0.0.1"},
+		},
+		{
+			testName: "member of another cluster",
+			owner:    &memberOwner{Cluster: "stage", Namespace: "dev", Name: "api", UID: "uid-1", ID: "10.0.0.1"},
+		},
+		{
+			testName: "member without owner",
+		},
+	}
+
+	for _, tc := range testCases {
+		existing := newZKMember()
+		existing.Owner = tc.owner
+		member, owned := ownedMember(existing, members)
+		assert.Equal(t, tc.member, member, tc.testName)
+		assert.Equal(t, tc.owned, owned, tc.testName)
+	}
+}
+
+func TestMemberOwnerData(t *testing.T) {
+	member := newZKMember()
+	member.addServiceEndpoint("http", "10.0.0.1", 80)
+	data, err := member.marshalJSON()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "announser")
+
+	member.Owner = &memberOwner{Cluster: "prod", Namespace: "dev", Name: "api", UID: "uid-1", ID: "10.0.0.1"}
+	data, err = member.marshalJSON()
+	assert.Nil(t, err)
+	parsed, err := newZKMember().unmarshalJSON(data)
+	assert.Nil(t, err)
+	assert.Equal(t, member.Owner, parsed.Owner)
+}
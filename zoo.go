@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
@@ -11,29 +19,284 @@ import (
 
 var (
 	memberPrefix = "member_"
+	// parentData marks the parent znodes created by the announser, only
+	// those are deleted again once empty
+	parentData = []byte("k8s-zk-announser")
+	// protectedMember matches the sequential member names with a protected prefix
+	protectedMember = regexp.MustCompile("^" + memberPrefix + "[0-9a-f]{32}_[0-9]+$")
 )
 
+// zooConfig holds the zookeeper connection and write options
+type zooConfig struct {
+	connectString     string
+	exhibitorURL      string        // discover the servers with the exhibitor rest api
+	srvDomain         string        // discover the servers with _zookeeper._tcp SRV records
+	discoveryInterval time.Duration // interval to discover the servers
+	authFile          string        // user:password digest credentials
+	acl               []zk.ACL      // acl of created znodes
+	persistent        bool          // write persistent instead of ephemeral members
+	deterministic     bool          // name members member_<cluster>_<namespace>_<name>_<index> instead of sequential names
+	cluster           string        // cluster name in deterministic member names
+	cleanupParents    bool          // delete the parent znodes created for members once empty
+}
+
+// zkConn is the part of the zookeeper connection the members are written
+// with, a *zk.Conn outside of tests
+type zkConn interface {
+	AddAuth(scheme string, auth []byte) error
+	Children(path string) ([]string, *zk.Stat, error)
+	Delete(path string, version int32) error
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Multi(ops ...interface{}) ([]zk.MultiResponse, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	SetACL(path string, acl []zk.ACL, version int32) (*zk.Stat, error)
+	SessionID() int64
+	Close()
+}
+
 // Zoo zookeeper main struct
 type Zoo struct {
-	conn   *zk.Conn
-	active *activeMembers
+	config     zooConfig
+	conn       zkConn
+	discovery  *pollingHostProvider // discovers the servers, nil for the servers of the connect string
+	chroot     string
+	auth       []byte
+	active     *activeMembers
+	newSession chan struct{}
+
+	watched      map[string]bool // member znodes with a watch
+	memberEvents chan zk.Event   // fired watches of member znodes
+	drifted      map[string]bool // services to reconcile when processed next
 }
 
 // Init the active memebers map
-func (z *Zoo) Init() {
+func (z *Zoo) Init(config zooConfig) {
+	z.config = config
+	if len(z.config.acl) == 0 {
+		z.config.acl = zk.WorldACL(zk.PermAll)
+	}
 	z.active = newActiveMembers()
+	z.newSession = make(chan struct{}, 1)
+	z.watched = make(map[string]bool)
+	z.memberEvents = make(chan zk.Event)
+	z.drifted = make(map[string]bool)
 }
 
-// Conn (connect to zookeeper)
-func (z *Zoo) Conn(server string) error {
-	c, _, err := zk.Connect([]string{server}, 10*time.Second) //*10)
+// parseConnectString splits a zookeeper connect string like
+// host1:2181,host2:2181/chroot into the servers and the chroot path
+func parseConnectString(connectString string) ([]string, string, error) {
+	hosts := connectString
+	chroot := ""
+	if i := strings.Index(connectString, "/"); i != -1 {
+		hosts = connectString[:i]
+		chroot = path.Clean(connectString[i:])
+		if chroot == "/" {
+			chroot = ""
+		}
+	}
+
+	var servers []string
+	for _, server := range strings.Split(hosts, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, "", fmt.Errorf("no zookeeper servers in %q", connectString)
+	}
+	return servers, chroot, nil
+}
+
+// Conn (connect to zookeeper) using a connect string with one or more
+// servers and an optional chroot
+func (z *Zoo) Conn() error {
+	servers, chroot, err := parseConnectString(z.config.connectString)
+	if err != nil {
+		return err
+	}
+	var c *zk.Conn
+	var events <-chan zk.Event
+	if hp := z.hostProvider(); hp != nil {
+		c, events, err = zk.Connect(servers, 10*time.Second, zk.WithHostProvider(hp))
+		z.discovery = hp
+	} else {
+		c, events, err = zk.Connect(servers, 10*time.Second) //*10)
+	}
 	if err != nil {
 		return err
 	}
 	z.conn = c
+	z.chroot = chroot
+	err = z.addAuth()
+	if err != nil {
+		z.Close()
+		return err
+	}
+	go z.watchSession(events)
+	return nil
+}
+
+// Close stops discovering the servers and closes the connection
+func (z *Zoo) Close() {
+	if z.discovery != nil {
+		z.discovery.Stop()
+	}
+	if z.conn != nil {
+		z.conn.Close()
+	}
+}
+
+// hostProvider returns the provider discovering the servers, nil for the
+// servers of the connect string
+func (z *Zoo) hostProvider() *pollingHostProvider {
+	if z.config.exhibitorURL != "" {
+		client := &http.Client{Timeout: 10 * time.Second}
+		return newPollingHostProvider(newExhibitorLister(z.config.exhibitorURL, client), z.config.discoveryInterval)
+	}
+	if z.config.srvDomain != "" {
+		return newPollingHostProvider(newSRVLister(z.config.srvDomain, net.LookupSRV), z.config.discoveryInterval)
+	}
+	return nil
+}
+
+// addAuth authenticates with the digest credentials of the auth file. The
+// zk client submits added credentials again after reconnects, new
+// credentials are added when the auth file changed
+func (z *Zoo) addAuth() error {
+	if z.config.authFile == "" {
+		return nil
+	}
+	auth, err := readAuthFile(z.config.authFile)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(auth, z.auth) {
+		return nil
+	}
+	err = z.conn.AddAuth("digest", auth)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with zookeeper: %v", err.Error())
+	}
+	z.auth = auth
+	log.Infof("authenticated with zookeeper using %v", z.config.authFile)
 	return nil
 }
 
+// watchSession signals newSession when the connection gets a new session.
+// All ephemeral members of the previous session are gone by then
+func (z *Zoo) watchSession(events <-chan zk.Event) {
+	var sessionID int64
+	for event := range events {
+		if event.Type != zk.EventSession {
+			continue
+		}
+		log.Debugf("zookeeper session state: %v", event.State)
+		switch event.State {
+		case zk.StateExpired:
+			log.Warnf("zookeeper session expired")
+		case zk.StateHasSession:
+			id := z.conn.SessionID()
+			if sessionID != 0 && sessionID != id {
+				log.Infof("zookeeper got new session: %x", id)
+				if err := z.addAuth(); err != nil {
+					log.Errorf("%v", err.Error())
+				}
+				select {
+				case z.newSession <- struct{}{}:
+				default:
+				}
+			}
+			sessionID = id
+		}
+	}
+}
+
+// ResetActive forgets all active members, used once the session that owned
+// the ephemeral members is gone. Persistent members outlive the session, the
+// watches and found drift of the session are forgotten in both modes
+func (z *Zoo) ResetActive() {
+	z.watched = make(map[string]bool)
+	z.drifted = make(map[string]bool)
+	if z.config.persistent {
+		return
+	}
+	z.active = newActiveMembers()
+}
+
+// addActive records the member written to the znode and watches the znode
+// for changes by others
+func (z *Zoo) addActive(name, id, znode string, member *zkMember) {
+	z.active.add(name, id, znode, member)
+	z.watchMember(znode)
+}
+
+// watchMember sets a watch on the member znode unless it is watched. The
+// fired watch is sent to memberEvents
+func (z *Zoo) watchMember(znode string) {
+	if z.watched[znode] {
+		return
+	}
+	_, _, ch, err := z.conn.ExistsW(znode)
+	if err != nil {
+		log.Debugf("failed to watch member %v: %v", znode, err.Error())
+		return
+	}
+	z.watched[znode] = true
+	go func() {
+		if event, ok := <-ch; ok {
+			z.memberEvents <- event
+		}
+	}()
+}
+
+// HandleMemberEvent checks an active member after its watch fired. A member
+// deleted by others is forgotten so it is added again, a member modified by
+// others is written again. It returns the service key and a description when
+// the member was tampered with
+func (z *Zoo) HandleMemberEvent(event zk.Event) (string, string, bool) {
+	delete(z.watched, event.Path)
+	name, id, ok := z.active.find(event.Path)
+	if !ok || event.Type == zk.EventNotWatching {
+		return "", "", false
+	}
+
+	data, _, err := z.conn.Get(event.Path)
+	if err == zk.ErrNoNode {
+		z.active.delete(name, id)
+		message := fmt.Sprintf("member %v of service %v was deleted in zookeeper by others", event.Path, name)
+		log.Warnf("%v", message)
+		return name, message, true
+	} else if err != nil {
+		log.Errorf("failed to check member %v: %v", event.Path, err.Error())
+		z.watchMember(event.Path)
+		return "", "", false
+	}
+
+	defer z.watchMember(event.Path)
+	expected, err := z.active.getMember(name, id).marshalJSON()
+	if err != nil || bytes.Equal(data, expected) {
+		return "", "", false
+	}
+	message := fmt.Sprintf("member %v of service %v was modified in zookeeper by others", event.Path, name)
+	log.Warnf("%v", message)
+	_, err = z.conn.Set(event.Path, expected, -1)
+	if err != nil {
+		log.Errorf("failed to write member %v again: %v", event.Path, err.Error())
+	}
+	return name, message, true
+}
+
+// chrootPath returns the path rooted under the chroot
+func (z *Zoo) chrootPath(p string) string {
+	if z.chroot == "" {
+		return p
+	}
+	return path.Join(z.chroot, p)
+}
+
 func (z *Zoo) splitPaths(fullPath string) []string {
 	var parts []string
 
@@ -55,19 +318,188 @@ func (z *Zoo) splitPaths(fullPath string) []string {
 	return result
 }
 
-// createFullPath makes sure all the znodes are created for the parent directories
-func (z *Zoo) createFullPath(path string) error {
-	paths := z.splitPaths(path)
-	for _, key := range paths {
-		log.Debugf("create path key: %s", key)
-		_, err := z.conn.Create(key, nil, 0, zk.WorldACL(zk.PermAll))
-		if err != nil && err != zk.ErrNodeExists {
-			log.Errorf("error creating full zk path: %s\n", err.Error())
-			return err
+// deterministicMemberName returns the member name of the service key and
+// index in the cluster, so announsers of other clusters use other names
+func deterministicMemberName(cluster, name string, index int) string {
+	if cluster != "" {
+		name = cluster + "/" + name
+	}
+	return fmt.Sprintf("%s%s_%d", memberPrefix, strings.Replace(name, "/", "_", -1), index)
+}
+
+// deterministicMemberIndex returns the index of the deterministic member name
+// of the service key, -1 for the names of other services and sequential names
+func deterministicMemberIndex(cluster, name, child string) int {
+	prefix := strings.TrimSuffix(deterministicMemberName(cluster, name, 0), "0")
+	if !strings.HasPrefix(child, prefix) {
+		return -1
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(child, prefix))
+	if err != nil || index < 0 {
+		return -1
+	}
+	return index
+}
+
+// protectedMemberPrefix returns a member name prefix with a random guid,
+// used to find a sequential member again when the create response was lost
+func protectedMemberPrefix() (string, error) {
+	var guid [16]byte
+	_, err := io.ReadFull(rand.Reader, guid[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%x_", memberPrefix, guid), nil
+}
+
+// memberZnode returns the path the member is created with. Sequential names
+// get a protected prefix, deterministic names the lowest index not used by
+// the active members of the service in the same path
+func (z *Zoo) memberZnode(member *zkMember) (string, error) {
+	memberPath := z.chrootPath(member.path)
+	if !z.config.deterministic {
+		prefix, err := protectedMemberPrefix()
+		if err != nil {
+			return "", err
 		}
+		return path.Join(memberPath, prefix), nil
+	}
+	used := make(map[int]bool)
+	for _, id := range z.active.ids(member.name) {
+		znode := z.active.get(member.name, id)
+		if path.Dir(znode) == memberPath {
+			used[deterministicMemberIndex(z.config.cluster, member.name, path.Base(znode))] = true
+		}
+	}
+	index := 0
+	for used[index] {
+		index++
 	}
+	return path.Join(memberPath, deterministicMemberName(z.config.cluster, member.name, index)), nil
+}
 
-	return nil
+// findProtectedMember returns the member created with the protected name
+// prefix of znode when the create response was lost, empty if the create was
+// not applied. Deterministic names are claimed by the next create instead
+func (z *Zoo) findProtectedMember(znode string, err error) string {
+	if z.config.deterministic || (err != zk.ErrConnectionClosed && err != zk.ErrSessionExpired) {
+		return ""
+	}
+	children, _, err := z.conn.Children(path.Dir(znode))
+	if err != nil {
+		return ""
+	}
+	for _, child := range children {
+		if strings.HasPrefix(child, path.Base(znode)) {
+			created := path.Join(path.Dir(znode), child)
+			log.Infof("found service member %s after the create response was lost", created)
+			return created
+		}
+	}
+	return ""
+}
+
+// memberACL returns the acl of the member znode
+func (z *Zoo) memberACL(member *zkMember) []zk.ACL {
+	if len(member.acl) != 0 {
+		return member.acl
+	}
+	return z.config.acl
+}
+
+// parentACL returns the acl of the parent znodes created for the member
+func (z *Zoo) parentACL(member *zkMember) []zk.ACL {
+	if len(member.parentACL) != 0 {
+		return member.parentACL
+	}
+	return z.config.acl
+}
+
+// parentData returns the data of created parent znodes, the parents are
+// only marked when they are cleaned up
+func (z *Zoo) parentData() []byte {
+	if !z.config.cleanupParents {
+		return nil
+	}
+	return parentData
+}
+
+// deleteEmptyParents deletes the parent znodes of the member path created
+// by the announser that are empty, up to the chroot
+func (z *Zoo) deleteEmptyParents(memberPath string) {
+	if !z.config.cleanupParents {
+		return
+	}
+	for znode := memberPath; znode != "/" && znode != z.chroot; znode = path.Dir(znode) {
+		data, stat, err := z.conn.Get(znode)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil || stat.NumChildren != 0 || !bytes.Equal(data, parentData) {
+			return
+		}
+		err = z.conn.Delete(znode, stat.Version)
+		if err != nil && err != zk.ErrNoNode {
+			log.Debugf("failed to delete empty parent %v: %v", znode, err.Error())
+			return
+		}
+		log.Infof("deleted empty parent: %v", znode)
+	}
+}
+
+// createOps returns the create requests for the missing parent znodes of
+// the member path followed by the create request of the member znode
+func (z *Zoo) createOps(znode string, member *zkMember) ([]interface{}, error) {
+	var ops []interface{}
+	for _, key := range z.splitPaths(z.chrootPath(member.path)) {
+		exists, _, err := z.conn.Exists(key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			log.Debugf("create path key: %s", key)
+			ops = append(ops, &zk.CreateRequest{Path: key, Data: z.parentData(), Acl: z.parentACL(member)})
+		}
+	}
+
+	memberData, err := member.marshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var flags int32
+	if !z.config.persistent {
+		flags |= zk.FlagEphemeral
+	}
+	if !z.config.deterministic {
+		flags |= zk.FlagSequence
+	}
+	ops = append(ops, &zk.CreateRequest{
+		Path:  znode,
+		Data:  memberData,
+		Acl:   z.memberACL(member),
+		Flags: flags,
+	})
+	return ops, nil
+}
+
+// replaceOps returns the ops creating the member znode and deleting the
+// znode it replaces
+func (z *Zoo) replaceOps(znode, created string, member *zkMember) ([]interface{}, error) {
+	ops, err := z.createOps(created, member)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, &zk.DeleteRequest{Path: znode, Version: -1}), nil
+}
+
+// createdZnode returns the znode created by the member create of the ops,
+// the last create before the delete of a replace
+func createdZnode(ops []interface{}, resp []zk.MultiResponse) string {
+	for i := len(ops) - 1; i >= 0 && i < len(resp); i-- {
+		if _, ok := ops[i].(*zk.CreateRequest); ok {
+			return resp[i].String
+		}
+	}
+	return ""
 }
 
 // AddServiceMember add new zk member
@@ -75,51 +507,585 @@ func (z *Zoo) AddServiceMember(member *zkMember) error {
 	if !member.anyEndpoints() {
 		return fmt.Errorf("failed to add no service endpoints")
 	}
-	if z.active.keyIn(member.name) {
+	if z.active.get(member.name, member.id) != "" {
 		return fmt.Errorf("will not add member exists in zk")
 	}
-	err := z.createFullPath(member.path)
+	respPath, err := z.createMember(member)
 	if err != nil {
 		return err
 	}
+	log.Infof("added service member: %s/%s (uid %s) with path: %s", member.name, member.id, member.uid, respPath)
+	z.addActive(member.name, member.id, respPath, member)
+	return nil
+}
+
+// UpdateServiceMember rewrites the data of an existing member in place, or
+// moves the member when the zookeeper path changed
+func (z *Zoo) UpdateServiceMember(member *zkMember) error {
+	if !member.anyEndpoints() {
+		return fmt.Errorf("failed to update no service endpoints")
+	}
+	znode := z.active.get(member.name, member.id)
+	if znode == "" {
+		return fmt.Errorf("Missing path for service %v member %v", member.name, member.id)
+	}
+	written := z.active.getMember(member.name, member.id)
+	if written != nil && written.path != member.path {
+		return z.moveMember(znode, member)
+	}
+	if !member.sameACL(written) {
+		_, err := z.conn.SetACL(znode, z.memberACL(member), -1)
+		if err != nil && err != zk.ErrNoNode {
+			return fmt.Errorf("failed to update acl of service member in path %v err: %v", znode, err.Error())
+		}
+		log.Infof("updated acl of service member: %s/%s with path: %s", member.name, member.id, znode)
+	}
+	if member.sameData(written) && !z.active.isModified(member.name, member.id) {
+		z.addActive(member.name, member.id, znode, member)
+		return nil
+	}
 
 	memberData, err := member.marshalJSON()
 	if err != nil {
 		return err
 	}
+	_, err = z.conn.Set(znode, memberData, -1)
+	if err == zk.ErrNoNode {
+		log.Infof("service member %s gone from zk, adding it again", znode)
+		z.active.delete(member.name, member.id)
+		return z.AddServiceMember(member)
+	} else if err != nil {
+		return fmt.Errorf("failed to update service member in path %v err: %v", znode, err.Error())
+	}
+	log.Infof("updated service member: %s/%s with path: %s", member.name, member.id, znode)
+	z.addActive(member.name, member.id, znode, member)
+	return nil
+}
 
-	path := fmt.Sprintf("%s/%s", member.path, memberPrefix)
+// replaceMember creates the member and deletes the znode of the member it
+// replaces in one transaction, so consumers never see both or neither
+func (z *Zoo) replaceMember(znode string, member *zkMember) (string, error) {
+	created, err := z.memberZnode(member)
+	if err != nil {
+		return "", err
+	}
+	if z.config.deterministic {
+		if path.Dir(znode) == z.chrootPath(member.path) {
+			return z.rewriteMember(znode, member)
+		}
+		claimed, err := z.claimMember(created, member)
+		if err != nil {
+			return "", err
+		} else if claimed {
+			return created, z.deleteZnode(znode)
+		}
+	}
+	ops, err := z.replaceOps(znode, created, member)
+	if err != nil {
+		return "", err
+	}
 
-	log.Debugf("trying to add service member with path: %s", member.path)
-	respPath, err := z.conn.Create(
-		path,
-		memberData,
-		zk.FlagEphemeral|zk.FlagSequence,
-		zk.WorldACL(zk.PermAll),
-	)
-
-	if err == zk.ErrNodeExists {
-		return nil
-	} else if err != nil {
-		log.Errorf("failed to create service member in path: %s  err: %s ", member.path, err.Error())
+	log.Debugf("trying to replace service member %s with path: %s", znode, member.path)
+	resp, err := z.conn.Multi(ops...)
+	if err != nil {
+		if found := z.findProtectedMember(created, err); found != "" {
+			return found, nil
+		}
+		if exists, _, existsErr := z.conn.Exists(znode); existsErr == nil && !exists {
+			log.Infof("replaced service member %s gone from zk, adding it again", znode)
+			return z.createMember(member)
+		}
+		log.Errorf("failed to replace service member %s in path: %s  err: %s ", znode, member.path, err.Error())
+		return "", err
+	}
+	z.deleteEmptyParents(path.Dir(znode))
+	return createdZnode(ops, resp), nil
+}
+
+// moveMember replaces the member znode with a member in the new path
+func (z *Zoo) moveMember(znode string, member *zkMember) error {
+	respPath, err := z.replaceMember(znode, member)
+	if err != nil {
 		return err
 	}
-	log.Infof("added service member: %s with path: %s", member.name, respPath)
-	z.active.add(member.name, respPath)
+	z.addActive(member.name, member.id, respPath, member)
+	log.Infof("moved service member: %s/%s from path: %s to path: %s", member.name, member.id, znode, respPath)
 	return nil
 }
 
+// rewriteMember writes the member to the deterministic name of the member
+// it replaces in the same path
+func (z *Zoo) rewriteMember(znode string, member *zkMember) (string, error) {
+	memberData, err := member.marshalJSON()
+	if err != nil {
+		return "", err
+	}
+	_, err = z.conn.Set(znode, memberData, -1)
+	if err == zk.ErrNoNode {
+		log.Infof("replaced service member %s gone from zk, adding it again", znode)
+		return z.createMember(member)
+	} else if err != nil {
+		return "", err
+	}
+	return znode, nil
+}
+
+// claimableMember returns if the existing znode with the deterministic name
+// of the member was written for the same service and can be claimed: an
+// ephemeral znode of this session or a persistent znode owned by the service.
+// It returns recreate for a znode to delete and create again: an ephemeral
+// znode of an earlier session with the endpoints of the member, or a znode of
+// the service written in the other member mode or for an earlier uid
+func claimableMember(existing *zkMember, ephemeralOwner, sessionID int64, config zooConfig, member *zkMember) (claim, recreate bool) {
+	if ephemeralOwner != 0 {
+		if ephemeralOwner == sessionID {
+			return !config.persistent, config.persistent
+		}
+		return false, isStaleMember(existing, []*zkMember{member})
+	}
+	owned := existing.Owner != nil && existing.Owner.Cluster == config.cluster && existing.Owner.key() == member.name
+	if config.persistent && existing.Owner.sameService(member.Owner) {
+		return true, false
+	}
+	return false, owned
+}
+
+// claimMember takes over the existing znode with the deterministic name of
+// the member, written before a restart. A znode written for another service
+// is never claimed or deleted
+func (z *Zoo) claimMember(znode string, member *zkMember) (bool, error) {
+	data, stat, err := z.conn.Get(znode)
+	if err == zk.ErrNoNode {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	existing, err := newZKMember().unmarshalJSON(data)
+	if err != nil {
+		return false, fmt.Errorf("will not claim service member %s with unknown data", znode)
+	}
+	claim, recreate := claimableMember(existing, stat.EphemeralOwner, z.conn.SessionID(), z.config, member)
+	if recreate {
+		err = z.conn.Delete(znode, stat.Version)
+		if err != nil && err != zk.ErrNoNode {
+			return false, err
+		}
+		log.Infof("deleted service member %s left by an earlier session", znode)
+		return false, nil
+	} else if !claim {
+		return false, fmt.Errorf("will not claim service member %s written for another service", znode)
+	}
+
+	memberData, err := member.marshalJSON()
+	if err != nil {
+		return false, err
+	}
+	_, err = z.conn.Set(znode, memberData, stat.Version)
+	if err == zk.ErrNoNode {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	_, err = z.conn.SetACL(znode, z.memberACL(member), -1)
+	if err != nil && err != zk.ErrNoNode {
+		return false, err
+	}
+	log.Infof("claimed existing service member %s", znode)
+	return true, nil
+}
+
+// createMember writes the member as a new znode, the missing parent znodes
+// are created in the same transaction. An existing znode with the
+// deterministic name of the member is claimed instead
+func (z *Zoo) createMember(member *zkMember) (string, error) {
+	znode, err := z.memberZnode(member)
+	if err != nil {
+		return "", err
+	}
+	if z.config.deterministic {
+		claimed, err := z.claimMember(znode, member)
+		if err != nil {
+			return "", err
+		} else if claimed {
+			return znode, nil
+		}
+	}
+	ops, err := z.createOps(znode, member)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debugf("trying to add service member with path: %s", member.path)
+	resp, err := z.conn.Multi(ops...)
+	if err != nil {
+		if found := z.findProtectedMember(znode, err); found != "" {
+			return found, nil
+		}
+		log.Errorf("failed to create service member in path: %s  err: %s ", member.path, err.Error())
+		return "", err
+	}
+	return createdZnode(ops, resp), nil
+}
+
 // DeleteServiceMember delete member
 func (z *Zoo) DeleteServiceMember(member *zkMember) error {
-	path := z.active.get(member.name)
+	return z.deleteMember(member.name, member.id)
+}
+
+// DeleteServiceMembers delete all members of a service
+func (z *Zoo) DeleteServiceMembers(name string) error {
+	var errs []string
+	for _, id := range z.active.ids(name) {
+		if err := z.deleteMember(name, id); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to delete members: %v", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// SyncServiceMembers adds the members not yet in zk, updates the changed
+// members and deletes the active members of the service that are no longer
+// wanted. New members replace deleted members in one transaction
+func (z *Zoo) SyncServiceMembers(name string, members []*zkMember) error {
+	var errs []string
+	var added []*zkMember
+	wanted := make(map[string]bool)
+	for _, member := range members {
+		wanted[member.id] = true
+		if z.active.get(member.name, member.id) == "" {
+			added = append(added, member)
+			continue
+		}
+		if err := z.UpdateServiceMember(member); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	var removed []string
+	for _, id := range z.active.ids(name) {
+		if !wanted[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	for len(added) != 0 && len(removed) != 0 {
+		member, id := added[0], removed[0]
+		added, removed = added[1:], removed[1:]
+		if err := z.replaceServiceMember(name, id, member); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, member := range added {
+		if err := z.AddServiceMember(member); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, id := range removed {
+		if err := z.deleteMember(name, id); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to sync members: %v", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// replaceServiceMember replaces the active member id of the service with a new member
+func (z *Zoo) replaceServiceMember(name, id string, member *zkMember) error {
+	if !member.anyEndpoints() {
+		return fmt.Errorf("failed to add no service endpoints")
+	}
+	znode := z.active.get(name, id)
+	respPath, err := z.replaceMember(znode, member)
+	if err != nil {
+		return err
+	}
+	z.active.delete(name, id)
+	z.addActive(member.name, member.id, respPath, member)
+	log.Infof("replaced service member: %s/%s with path: %s by %s with path: %s", name, id, znode, member.id, respPath)
+	return nil
+}
+
+// strayMembers are the member znodes in the paths of a service that are not
+// active members
+type strayMembers struct {
+	adopt map[string]*zkMember // persistent members of the service still wanted, keyed by znode
+	stale []string             // members left by an earlier session or a lost create
+	named []string             // deterministic names of the service
+}
+
+func (s *strayMembers) len() int {
+	return len(s.adopt) + len(s.stale) + len(s.named)
+}
+
+// findStrayMembers scans the paths of the members for member znodes of the
+// service that are not active members, without changing zookeeper
+func (z *Zoo) findStrayMembers(name string, members []*zkMember) (*strayMembers, error) {
+	owned := make(map[string]bool)
+	for _, id := range z.active.ids(name) {
+		owned[z.active.get(name, id)] = true
+	}
+	paths := make(map[string]bool)
+	for _, member := range members {
+		paths[member.path] = true
+	}
+
+	stray := strayMembers{adopt: make(map[string]*zkMember)}
+	adopted := make(map[string]bool)
+	var errs []string
+	for memberPath := range paths {
+		children, err := z.memberChildren(memberPath)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, znode := range children {
+			if owned[znode] {
+				continue
+			}
+			if deterministicMemberIndex(z.config.cluster, name, path.Base(znode)) != -1 {
+				stray.named = append(stray.named, znode)
+				continue
+			}
+			data, stat, err := z.conn.Get(znode)
+			if err == zk.ErrNoNode {
+				continue
+			} else if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if stat.EphemeralOwner == z.conn.SessionID() {
+				// a create of this session whose response was lost
+				if _, _, tracked := z.active.find(znode); !tracked && protectedMember.MatchString(path.Base(znode)) {
+					stray.stale = append(stray.stale, znode)
+				}
+				continue
+			}
+			existing, err := newZKMember().unmarshalJSON(data)
+			if err != nil {
+				continue
+			}
+			if stat.EphemeralOwner != 0 {
+				if isStaleMember(existing, members) {
+					stray.stale = append(stray.stale, znode)
+				}
+				continue
+			}
+			member, isOwned := ownedMember(existing, members)
+			if member != nil && !adopted[member.id] && z.active.get(name, member.id) == "" {
+				existing.name = name
+				existing.uid = member.uid
+				existing.id = member.id
+				existing.path = memberPath
+				existing.acl = member.acl
+				adopted[member.id] = true
+				stray.adopt[znode] = existing
+			} else if isOwned {
+				stray.stale = append(stray.stale, znode)
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("failed to scan members: %v", strings.Join(errs, ", "))
+	}
+	return &stray, nil
+}
+
+// ReconcileServiceMembers syncs the members of a service and deletes the
+// members left in its paths by an earlier session of the announser, or by a
+// create of this session whose response was lost and not found. Persistent
+// members written for the service are adopted when still wanted. It returns
+// the number of deleted members
+func (z *Zoo) ReconcileServiceMembers(name string, members []*zkMember) (int, error) {
+	stray, err := z.findStrayMembers(name, members)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reconcile members: %v", err.Error())
+	}
+	for znode, existing := range stray.adopt {
+		log.Infof("adopted persistent member: %v of service %v", znode, name)
+		z.addActive(name, existing.id, znode, existing)
+	}
+
+	err = z.SyncServiceMembers(name, members)
+	if err != nil {
+		return 0, err
+	}
+	owned := make(map[string]bool)
+	for _, id := range z.active.ids(name) {
+		owned[z.active.get(name, id)] = true
+	}
+	stale := stray.stale
+	for _, znode := range stray.named {
+		if !owned[znode] {
+			stale = append(stale, znode)
+		}
+	}
+
+	deleted := 0
+	var errs []string
+	for _, znode := range stale {
+		err := z.conn.Delete(znode, -1)
+		if err != nil && err != zk.ErrNoNode {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Infof("deleted stale member: %v of service %v", znode, name)
+		deleted++
+	}
+	if len(errs) != 0 {
+		return deleted, fmt.Errorf("failed to reconcile members: %v", strings.Join(errs, ", "))
+	}
+	delete(z.drifted, name)
+	return deleted, nil
+}
+
+// ProcessServiceMembers syncs the members of a service, a service with drift
+// found by CheckServiceMembers is reconciled
+func (z *Zoo) ProcessServiceMembers(name string, members []*zkMember) error {
+	if !z.drifted[name] {
+		return z.SyncServiceMembers(name, members)
+	}
+	deleted, err := z.ReconcileServiceMembers(name, members)
+	if deleted != 0 {
+		log.Infof("deleted %v stale members of service %v", deleted, name)
+	}
+	return err
+}
+
+// DeleteOwnedMembers deletes the persistent members of the owner in the path,
+// including the members written before the announser restarted. It returns
+// the number of deleted members
+func (z *Zoo) DeleteOwnedMembers(owner *memberOwner, memberPath string) (int, error) {
+	children, err := z.memberChildren(memberPath)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, znode := range children {
+		data, stat, err := z.conn.Get(znode)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		if stat.EphemeralOwner != 0 {
+			continue
+		}
+		existing, err := newZKMember().unmarshalJSON(data)
+		if err != nil || !owner.sameService(existing.Owner) {
+			continue
+		}
+		err = z.conn.Delete(znode, -1)
+		if err != nil && err != zk.ErrNoNode {
+			return deleted, err
+		}
+		log.Infof("deleted persistent member: %v of service %v", znode, owner.key())
+		deleted++
+	}
+	if deleted != 0 {
+		z.deleteEmptyParents(z.chrootPath(memberPath))
+	}
+	return deleted, nil
+}
+
+// memberChildren returns the member znodes in the path
+func (z *Zoo) memberChildren(memberPath string) ([]string, error) {
+	memberPath = z.chrootPath(memberPath)
+	children, _, err := z.conn.Children(memberPath)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var znodes []string
+	for _, child := range children {
+		if strings.HasPrefix(child, memberPrefix) {
+			znodes = append(znodes, path.Join(memberPath, child))
+		}
+	}
+	return znodes, nil
+}
+
+// CheckServiceMembers finds the drift between the members of a service and
+// zookeeper without writing to zookeeper. Members gone from zk are forgotten
+// and modified members are marked, the drift is fixed when the service is
+// processed next. It returns the number of drifted members
+func (z *Zoo) CheckServiceMembers(name string, members []*zkMember) (int, error) {
+	drifted := 0
+	wanted := make(map[string]bool)
+	for _, member := range members {
+		wanted[member.id] = true
+		if z.active.get(name, member.id) == "" {
+			drifted++
+		}
+	}
+	for _, id := range z.active.ids(name) {
+		if !wanted[id] {
+			drifted++
+			continue
+		}
+		znode := z.active.get(name, id)
+		z.watchMember(znode)
+		data, _, err := z.conn.Get(znode)
+		if err == zk.ErrNoNode {
+			log.Infof("repair member: %v of service %v missing in zk", znode, name)
+			z.active.delete(name, id)
+			drifted++
+			continue
+		} else if err != nil {
+			return drifted, err
+		}
+		expected, err := z.active.getMember(name, id).marshalJSON()
+		if err != nil {
+			return drifted, err
+		}
+		if !bytes.Equal(data, expected) {
+			log.Infof("repair member: %v of service %v modified in zk", znode, name)
+			z.active.markModified(name, id)
+			drifted++
+		}
+	}
+
+	stray, err := z.findStrayMembers(name, members)
+	if err != nil {
+		return drifted, err
+	}
+	drifted += stray.len()
+	if drifted != 0 {
+		z.drifted[name] = true
+	}
+	return drifted, nil
+}
+
+// ActiveServices returns the keys of all services with active members
+func (z *Zoo) ActiveServices() []string {
+	return z.active.keys()
+}
+
+func (z *Zoo) deleteMember(name, id string) error {
+	path := z.active.get(name, id)
 	if path == "" {
-		return fmt.Errorf("Missing path for service %v", member.name)
+		return fmt.Errorf("Missing path for service %v member %v", name, id)
 	}
-	err := z.conn.Delete(path, 0)
+	err := z.deleteZnode(path)
 	if err != nil {
-		return fmt.Errorf("failed to delete service member in path %v err: %v", member.path, err.Error())
+		return err
 	}
 	log.Infof("deleted member: %v", path)
-	z.active.delete(member.name)
+	z.active.delete(name, id)
+	return nil
+}
+
+// deleteZnode deletes the member znode and its empty parents, a znode
+// already gone is not an error
+func (z *Zoo) deleteZnode(znode string) error {
+	err := z.conn.Delete(znode, -1)
+	if err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("failed to delete service member in path %v err: %v", znode, err.Error())
+	}
+	z.deleteEmptyParents(path.Dir(znode))
 	return nil
 }
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncParseConnectString(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		connectString string
+		servers       []string
+		chroot        string
+		expectedError bool
+	}{
+
+		{
+			testName:      "single server",
+			connectString: "localhost:2181",
+			servers:       []string{"localhost:2181"},
+			chroot:        "",
+		},
+
+		{
+			testName:      "multiple servers with chroot",
+			connectString: "host1:2181,host2:2181,host3:2181/announser/prod",
+			servers:       []string{"host1:2181", "host2:2181", "host3:2181"},
+			chroot:        "/announser/prod",
+		},
+
+		{
+			testName:      "root chroot",
+			connectString: "host1:2181, host2/",
+			servers:       []string{"host1:2181", "host2"},
+			chroot:        "",
+		},
+
+		{
+			testName:      "no servers",
+			connectString: "/chroot",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		servers, chroot, err := parseConnectString(tc.connectString)
+		if tc.expectedError == true {
+			assert.NotNil(t, err, tc.testName)
+		} else {
+			assert.Nil(t, err, tc.testName)
+			assert.Equal(t, tc.servers, servers, tc.testName)
+			assert.Equal(t, tc.chroot, chroot, tc.testName)
+		}
+	}
+}
+
+func TestChrootPath(t *testing.T) {
+	z := Zoo{}
+	assert.Equal(t, "/aurora/jobs", z.chrootPath("/aurora/jobs"))
+
+	z.chroot = "/announser"
+	assert.Equal(t, "/announser/aurora/jobs", z.chrootPath("/aurora/jobs"))
+}
+
+func TestDeterministicMemberName(t *testing.T) {
+	assert.Equal(t, "member_dev_api_0", deterministicMemberName("", "dev/api", 0))
+	assert.Equal(t, "member_prod-eu_dev_api_0", deterministicMemberName("prod-eu", "dev/api", 0))
+	assert.Equal(t, "member_prod-eu_dev_api_12", deterministicMemberName("prod-eu", "dev/api", 12))
+
+	assert.Equal(t, 12, deterministicMemberIndex("prod-eu", "dev/api", "member_prod-eu_dev_api_12"))
+	assert.Equal(t, -1, deterministicMemberIndex("prod-eu", "dev/api", "member_prod-us_dev_api_12"))
+	assert.Equal(t, -1, deterministicMemberIndex("prod-eu", "dev/api", "member_dev_api_12"))
+	assert.Equal(t, -1, deterministicMemberIndex("prod-eu", "dev/api", "member_prod-eu_dev_api-v2_0"))
+	assert.Equal(t, -1, deterministicMemberIndex("prod-eu", "dev/api", "member_prod-eu_prod_api_0"))
+	assert.Equal(t, -1, deterministicMemberIndex("prod-eu", "dev/api", "member_0000000001"))
+	assert.Equal(t, -1, deterministicMemberIndex("prod-eu", "dev/api", "member_prod-eu_dev_api_"))
+	assert.Equal(t, 3, deterministicMemberIndex("", "dev/api", "member_dev_api_3"))
+}
+
+func TestClaimableMember(t *testing.T) {
+	owner := &memberOwner{Cluster: "prod-eu", Namespace: "dev", Name: "api", UID: "uid-1", ID: "10.0.0.1"}
+	newMember := func(host string, owner *memberOwner) *zkMember {
+		member := newZKMember()
+		member.name = "dev/api"
+		member.ServiceEndpoint = zkMemberUnite{Host: host, Port: 80}
+		member.Owner = owner
+		return member
+	}
+	otherUID := *owner
+	otherUID.UID = "uid-2"
+	otherCluster := *owner
+	otherCluster.Cluster = "prod-us"
+
+	testCases := []struct {
+		testName       string
+		persistent     bool
+		existing       *zkMember
+		ephemeralOwner int64
+		claim          bool
+		recreate       bool
+	}{
+		{testName: "ephemeral of this session", existing: newMember("10.0.0.2", nil), ephemeralOwner: 1, claim: true},
+		{testName: "ephemeral of an earlier session", existing: newMember("10.0.0.1", nil), ephemeralOwner: 2, recreate: true},
+		{testName: "ephemeral of another writer", existing: newMember("10.0.0.2", nil), ephemeralOwner: 2},
+		{testName: "persistent without owner", existing: newMember("10.0.0.1", nil)},
+		{testName: "persistent owned in ephemeral mode", existing: newMember("10.0.0.1", owner), recreate: true},
+		{testName: "persistent of the service", persistent: true, existing: newMember("10.0.0.2", owner), claim: true},
+		{testName: "persistent of an earlier uid", persistent: true, existing: newMember("10.0.0.1", &otherUID), recreate: true},
+		{testName: "persistent of another cluster", persistent: true, existing: newMember("10.0.0.1", &otherCluster)},
+		{testName: "persistent without owner in persistent mode", persistent: true, existing: newMember("10.0.0.1", nil)},
+		{testName: "ephemeral of this session in persistent mode", persistent: true, existing: newMember("10.0.0.1", nil), ephemeralOwner: 1, recreate: true},
+	}
+
+	for _, tc := range testCases {
+		config := zooConfig{persistent: tc.persistent, deterministic: true, cluster: "prod-eu"}
+		var memberOwner *memberOwner
+		if tc.persistent {
+			memberOwner = owner
+		}
+		claim, recreate := claimableMember(tc.existing, tc.ephemeralOwner, 1, config, newMember("10.0.0.1", memberOwner))
+		assert.Equal(t, tc.claim, claim, tc.testName)
+		assert.Equal(t, tc.recreate, recreate, tc.testName)
+	}
+}
+
+func TestMemberZnode(t *testing.T) {
+	newMember := func(id, memberPath string) *zkMember {
+		member := newZKMember()
+		member.name = "dev/api"
+		member.id = id
+		member.path = memberPath
+		return member
+	}
+	z := Zoo{}
+	z.Init(zooConfig{})
+	z.chroot = "/announser"
+	znode, err := z.memberZnode(newMember("10.0.0.1", "/aurora/jobs/api"))
+	assert.Nil(t, err)
+	assert.Regexp(t, "^/announser/aurora/jobs/api/member_[0-9a-f]{32}_$", znode)
+	other, _ := z.memberZnode(newMember("10.0.0.1", "/aurora/jobs/api"))
+	assert.NotEqual(t, znode, other)
+	assert.True(t, protectedMember.MatchString(path.Base(znode)+"0000000001"))
+	assert.False(t, protectedMember.MatchString(path.Base(znode)))
+	assert.False(t, protectedMember.MatchString("member_0000000001"))
+	assert.False(t, protectedMember.MatchString("member_prod-eu_dev_api_0"))
+
+	z.config.deterministic = true
+	z.config.cluster = "prod-eu"
+	z.active.add("dev/api", "10.0.0.1", "/announser/aurora/jobs/api/member_prod-eu_dev_api_0", newMember("10.0.0.1", "/aurora/jobs/api"))
+	z.active.add("dev/api", "10.0.0.2", "/announser/aurora/jobs/api/member_prod-eu_dev_api_2", newMember("10.0.0.2", "/aurora/jobs/api"))
+	znode, err = z.memberZnode(newMember("10.0.0.3", "/aurora/jobs/api"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/announser/aurora/jobs/api/member_prod-eu_dev_api_1", znode)
+	znode, err = z.memberZnode(newMember("10.0.0.3", "/aurora/jobs/api-v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/announser/aurora/jobs/api-v2/member_prod-eu_dev_api_0", znode)
+}
+
+func TestCreateOps(t *testing.T) {
+	member := newZKMember()
+	member.name = "dev/api"
+	member.id = "10.0.0.1"
+	member.path = "/aurora/jobs/api"
+	member.ServiceEndpoint = zkMemberUnite{Host: "10.0.0.1", Port: 80}
+
+	testCases := []struct {
+		testName   string
+		persistent bool
+		existing   []string
+		replace    string
+		paths      []string
+		flags      int32
+	}{
+		{
+			testName: "missing parents",
+			existing: []string{"/announser"},
+			paths:    []string{"/announser/aurora", "/announser/aurora/jobs", "/announser/aurora/jobs/api", "/announser/aurora/jobs/api/member_"},
+			flags:    zk.FlagEphemeral | zk.FlagSequence,
+		},
+		{
+			testName: "existing parents",
+			existing: []string{"/announser", "/announser/aurora", "/announser/aurora/jobs", "/announser/aurora/jobs/api"},
+			paths:    []string{"/announser/aurora/jobs/api/member_"},
+			flags:    zk.FlagEphemeral | zk.FlagSequence,
+		},
+		{
+			testName:   "replace persistent",
+			persistent: true,
+			existing:   []string{"/announser", "/announser/aurora", "/announser/aurora/jobs"},
+			replace:    "/announser/aurora/jobs/web/member_0000000001",
+			paths:      []string{"/announser/aurora/jobs/api", "/announser/aurora/jobs/api/member_", "/announser/aurora/jobs/web/member_0000000001"},
+			flags:      zk.FlagSequence,
+		},
+	}
+
+	for _, tc := range testCases {
+		z := Zoo{}
+		z.Init(zooConfig{persistent: tc.persistent})
+		z.chroot = "/announser"
+		conn := newFakeConn(1)
+		for _, znode := range tc.existing {
+			conn.create(znode, nil, 0)
+		}
+		z.conn = conn
+
+		var ops []interface{}
+		var err error
+		if tc.replace != "" {
+			ops, err = z.replaceOps(tc.replace, "/announser/aurora/jobs/api/member_", member)
+		} else {
+			ops, err = z.createOps("/announser/aurora/jobs/api/member_", member)
+		}
+		assert.Nil(t, err, tc.testName)
+
+		var paths []string
+		resp := make([]zk.MultiResponse, len(ops))
+		for i, op := range ops {
+			switch req := op.(type) {
+			case *zk.CreateRequest:
+				paths = append(paths, req.Path)
+				resp[i].String = req.Path
+				if req.Path != "/announser/aurora/jobs/api/member_" {
+					assert.Equal(t, int32(0), req.Flags, tc.testName)
+					continue
+				}
+				assert.Equal(t, tc.flags, req.Flags, tc.testName)
+				resp[i].String = req.Path + "0000000002"
+			case *zk.DeleteRequest:
+				paths = append(paths, req.Path)
+				assert.Equal(t, len(ops)-1, i, tc.testName)
+			}
+		}
+		assert.Equal(t, tc.paths, paths, tc.testName)
+		assert.Equal(t, "/announser/aurora/jobs/api/member_0000000002", createdZnode(ops, resp), tc.testName)
+		if tc.replace != "" {
+			assert.Equal(t, resp[len(resp)-2].String, createdZnode(ops, resp), tc.testName)
+		} else {
+			assert.Equal(t, resp[len(resp)-1].String, createdZnode(ops, resp), tc.testName)
+		}
+	}
+
+	z := Zoo{}
+	z.Init(zooConfig{})
+	conn := newFakeConn(1)
+	conn.errs["exists /aurora"] = zk.ErrConnectionClosed
+	z.conn = conn
+	_, err := z.createOps("/aurora/jobs/api/member_", member)
+	assert.Equal(t, zk.ErrConnectionClosed, err)
+}
+
+// newTestZoo returns a zoo writing to a fake connection of session 1
+func newTestZoo(config zooConfig) (*Zoo, *fakeConn) {
+	z := Zoo{}
+	z.Init(config)
+	conn := newFakeConn(1)
+	z.conn = conn
+	return &z, conn
+}
+
+// newTestMember returns the member id of the service key announsing host:port into memberPath
+func newTestMember(name, id, memberPath, host string, port int) *zkMember {
+	member := newZKMember()
+	member.name = name
+	member.id = id
+	member.path = memberPath
+	member.addServiceEndpoint("http", host, port)
+	return member
+}
+
+// memberData returns the znode data of the member
+func memberData(t *testing.T, member *zkMember) []byte {
+	data, err := member.marshalJSON()
+	assert.Nil(t, err)
+	return data
+}
+
+func TestUpdateServiceMember(t *testing.T) {
+	z, conn := newTestZoo(zooConfig{})
+	assert.Nil(t, z.AddServiceMember(newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 80)))
+	znode := z.active.get("dev/api", "10.0.0.1")
+	assert.Regexp(t, "^/aurora/jobs/api/member_[0-9a-f]{32}_0000000001$", znode)
+
+	// changed endpoints are written in place
+	member := newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 81)
+	assert.Nil(t, z.UpdateServiceMember(member))
+	assert.Equal(t, znode, z.active.get("dev/api", "10.0.0.1"))
+	assert.Equal(t, memberData(t, member), conn.znodes[znode].data)
+	assert.Equal(t, int32(1), conn.znodes[znode].version)
+
+	// unchanged members are not written
+	assert.Nil(t, z.UpdateServiceMember(newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 81)))
+	assert.Equal(t, int32(1), conn.znodes[znode].version)
+
+	// a changed path moves the member
+	member = newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api-v2", "10.0.0.1", 81)
+	assert.Nil(t, z.UpdateServiceMember(member))
+	moved := z.active.get("dev/api", "10.0.0.1")
+	assert.Regexp(t, "^/aurora/jobs/api-v2/member_[0-9a-f]{32}_0000000002$", moved)
+	assert.Nil(t, conn.znodes[znode])
+	assert.Equal(t, memberData(t, member), conn.znodes[moved].data)
+	assert.Equal(t, int64(1), conn.znodes[moved].ephemeralOwner)
+
+	// a member deleted in zookeeper is added again
+	assert.Nil(t, conn.Delete(moved, -1))
+	member = newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api-v2", "10.0.0.1", 82)
+	assert.Nil(t, z.UpdateServiceMember(member))
+	added := z.active.get("dev/api", "10.0.0.1")
+	assert.Regexp(t, "^/aurora/jobs/api-v2/member_[0-9a-f]{32}_0000000003$", added)
+	assert.Equal(t, memberData(t, member), conn.znodes[added].data)
+
+	// failed writes keep the active member
+	conn.errs["set "+added] = zk.ErrConnectionClosed
+	assert.NotNil(t, z.UpdateServiceMember(newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api-v2", "10.0.0.1", 83)))
+	assert.Equal(t, added, z.active.get("dev/api", "10.0.0.1"))
+	assert.Equal(t, memberData(t, member), conn.znodes[added].data)
+}
+
+func TestReplaceServiceMemberDeterministic(t *testing.T) {
+	z, conn := newTestZoo(zooConfig{deterministic: true, cluster: "prod-eu"})
+	assert.Nil(t, z.AddServiceMember(newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 80)))
+	znode := z.active.get("dev/api", "10.0.0.1")
+	assert.Equal(t, "/aurora/jobs/api/member_prod-eu_dev_api_0", znode)
+
+	// a replaced member in the same path keeps its name
+	member := newTestMember("dev/api", "10.0.0.2", "/aurora/jobs/api", "10.0.0.2", 80)
+	assert.Nil(t, z.replaceServiceMember("dev/api", "10.0.0.1", member))
+	assert.Equal(t, "", z.active.get("dev/api", "10.0.0.1"))
+	assert.Equal(t, znode, z.active.get("dev/api", "10.0.0.2"))
+	assert.Equal(t, memberData(t, member), conn.znodes[znode].data)
+	assert.Equal(t, []string{znode}, conn.children("/aurora/jobs/api"))
+}
+
+func TestReconcileServiceMembers(t *testing.T) {
+	z, conn := newTestZoo(zooConfig{})
+	other := newTestMember("dev/web", "10.0.0.9", "/aurora/jobs/api", "10.0.0.9", 80)
+	stale := newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 80)
+	conn.create("/aurora/jobs/api/member_0000000001", memberData(t, other), 2)
+	conn.create("/aurora/jobs/api/member_0000000002", memberData(t, stale), 2)
+	conn.create("/aurora/jobs/api/member_0000000003", memberData(t, other), 0)
+	conn.create("/aurora/jobs/api/member_0123456789abcdef0123456789abcdef_0000000004", memberData(t, stale), 1)
+	conn.create("/aurora/jobs/api/member_0000000005", memberData(t, other), 1)
+	conn.create("/aurora/jobs/api/other", nil, 0)
+	conn.sequence = 5
+
+	members := []*zkMember{newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 80)}
+	stray, err := z.findStrayMembers("dev/api", members)
+	assert.Nil(t, err)
+	sort.Strings(stray.stale)
+	assert.Equal(t, []string{
+		"/aurora/jobs/api/member_0000000002",
+		"/aurora/jobs/api/member_0123456789abcdef0123456789abcdef_0000000004",
+	}, stray.stale)
+	assert.Len(t, stray.adopt, 0)
+	assert.Len(t, stray.named, 0)
+
+	deleted, err := z.ReconcileServiceMembers("dev/api", members)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+	znode := z.active.get("dev/api", "10.0.0.1")
+	assert.Regexp(t, "^/aurora/jobs/api/member_[0-9a-f]{32}_0000000006$", znode)
+	kept := []string{
+		"/aurora/jobs/api/member_0000000001",
+		"/aurora/jobs/api/member_0000000003",
+		"/aurora/jobs/api/member_0000000005",
+		"/aurora/jobs/api/other",
+		znode,
+	}
+	sort.Strings(kept)
+	assert.Equal(t, kept, conn.children("/aurora/jobs/api"))
+
+	// scan errors delete nothing
+	conn.errs["children /aurora/jobs/api"] = zk.ErrConnectionClosed
+	_, err = z.ReconcileServiceMembers("dev/api", members)
+	assert.NotNil(t, err)
+	assert.Len(t, conn.children("/aurora/jobs/api"), 5)
+}
+
+func TestHandleMemberEvent(t *testing.T) {
+	z, conn := newTestZoo(zooConfig{})
+	member := newTestMember("dev/api", "10.0.0.1", "/aurora/jobs/api", "10.0.0.1", 80)
+	assert.Nil(t, z.AddServiceMember(member))
+	znode := z.active.get("dev/api", "10.0.0.1")
+	assert.True(t, z.watched[znode])
+
+	// a member modified by others is written again and watched again
+	_, err := conn.Set(znode, []byte("{}"), -1)
+	assert.Nil(t, err)
+	event := <-z.memberEvents
+	assert.Equal(t, zk.Event{Type: zk.EventNodeDataChanged, Path: znode}, event)
+	key, message, tampered := z.HandleMemberEvent(event)
+	assert.True(t, tampered)
+	assert.Equal(t, "dev/api", key)
+	assert.Contains(t, message, "modified")
+	assert.Equal(t, memberData(t, member), conn.znodes[znode].data)
+	assert.True(t, z.watched[znode])
+
+	// our own writes are no tampering
+	_, err = conn.Set(znode, memberData(t, member), -1)
+	assert.Nil(t, err)
+	_, _, tampered = z.HandleMemberEvent(<-z.memberEvents)
+	assert.False(t, tampered)
+	assert.True(t, z.watched[znode])
+
+	// a member deleted by others is forgotten
+	assert.Nil(t, conn.Delete(znode, -1))
+	key, message, tampered = z.HandleMemberEvent(<-z.memberEvents)
+	assert.True(t, tampered)
+	assert.Equal(t, "dev/api", key)
+	assert.Contains(t, message, "deleted")
+	assert.False(t, z.active.keyIn("dev/api"))
+	assert.False(t, z.watched[znode])
+
+	// watches of members no longer active or lost with the session
+	z.watched["/aurora/jobs/api/member_0000000009"] = true
+	_, _, tampered = z.HandleMemberEvent(zk.Event{Type: zk.EventNotWatching, Path: "/aurora/jobs/api/member_0000000009"})
+	assert.False(t, tampered)
+	assert.False(t, z.watched["/aurora/jobs/api/member_0000000009"])
+}
+
+func TestWatchMember(t *testing.T) {
+	z, conn := newTestZoo(zooConfig{})
+	conn.create("/aurora/jobs/api/member_0000000001", nil, 1)
+
+	z.watchMember("/aurora/jobs/api/member_0000000001")
+	assert.True(t, z.watched["/aurora/jobs/api/member_0000000001"])
+	watch := conn.watches["/aurora/jobs/api/member_0000000001"]
+	z.watchMember("/aurora/jobs/api/member_0000000001")
+	assert.True(t, watch == conn.watches["/aurora/jobs/api/member_0000000001"])
+
+	// failed watches are not marked, the next call sets them
+	conn.errs["exists /aurora/jobs/api/member_0000000002"] = zk.ErrConnectionClosed
+	z.watchMember("/aurora/jobs/api/member_0000000002")
+	assert.False(t, z.watched["/aurora/jobs/api/member_0000000002"])
+}
+
+func TestResetActive(t *testing.T) {
+	for _, persistent := range []bool{false, true} {
+		z, conn := newTestZoo(zooConfig{persistent: persistent})
+		conn.create("/aurora/jobs/api/member_0000000001", nil, 0)
+		z.addActive("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+		z.drifted["dev/api"] = true
+
+		z.ResetActive()
+		assert.Equal(t, persistent, z.active.keyIn("dev/api"))
+		assert.False(t, z.watched["/aurora/jobs/api/member_0000000001"])
+		assert.False(t, z.drifted["dev/api"])
+
+		// the member is watched again in the new session
+		z.addActive("dev/api", "10.0.0.1", "/aurora/jobs/api/member_0000000001", nil)
+		assert.True(t, z.watched["/aurora/jobs/api/member_0000000001"])
+	}
+}
+
+func TestDeleteEmptyParents(t *testing.T) {
+	testCases := []struct {
+		testName       string
+		cleanupParents bool
+		unmarked       string
+		child          string
+		kept           []string
+	}{
+		{
+			testName:       "marked empty parents up to the chroot",
+			cleanupParents: true,
+			kept:           []string{"/", "/announser"},
+		},
+		{
+			testName:       "parent with children",
+			cleanupParents: true,
+			child:          "/announser/aurora/jobs/web",
+			kept:           []string{"/", "/announser", "/announser/aurora", "/announser/aurora/jobs", "/announser/aurora/jobs/web"},
+		},
+		{
+			testName:       "unmarked parent",
+			cleanupParents: true,
+			unmarked:       "/announser/aurora/jobs",
+			kept:           []string{"/", "/announser", "/announser/aurora", "/announser/aurora/jobs"},
+		},
+		{
+			testName: "cleanup disabled",
+			kept:     []string{"/", "/announser", "/announser/aurora", "/announser/aurora/jobs", "/announser/aurora/jobs/api"},
+		},
+	}
+
+	for _, tc := range testCases {
+		z, conn := newTestZoo(zooConfig{cleanupParents: tc.cleanupParents})
+		z.chroot = "/announser"
+		conn.create("/announser", nil, 0)
+		for _, znode := range []string{"/announser/aurora", "/announser/aurora/jobs", "/announser/aurora/jobs/api"} {
+			conn.create(znode, parentData, 0)
+		}
+		if tc.unmarked != "" {
+			conn.znodes[tc.unmarked].data = []byte("other")
+		}
+		if tc.child != "" {
+			conn.create(tc.child, nil, 0)
+		}
+
+		z.deleteEmptyParents("/announser/aurora/jobs/api")
+		var kept []string
+		for znode := range conn.znodes {
+			kept = append(kept, znode)
+		}
+		sort.Strings(kept)
+		assert.Equal(t, tc.kept, kept, tc.testName)
+	}
+}
+
+// fakeConn is an in memory zookeeper tree the members are written to in tests
+type fakeConn struct {
+	session  int64
+	znodes   map[string]*fakeZnode
+	sequence int
+	watches  map[string]chan zk.Event
+	errs     map[string]error // returned for "op path", e.g. "set /aurora/jobs/api/member_0"
+}
+
+type fakeZnode struct {
+	data           []byte
+	version        int32
+	ephemeralOwner int64
+}
+
+func newFakeConn(session int64) *fakeConn {
+	return &fakeConn{
+		session: session,
+		znodes:  map[string]*fakeZnode{"/": {}},
+		watches: make(map[string]chan zk.Event),
+		errs:    make(map[string]error),
+	}
+}
+
+// create adds the znode and its missing parents
+func (c *fakeConn) create(znode string, data []byte, ephemeralOwner int64) {
+	if parent := path.Dir(znode); c.znodes[parent] == nil {
+		c.create(parent, nil, 0)
+	}
+	c.znodes[znode] = &fakeZnode{data: data, ephemeralOwner: ephemeralOwner}
+}
+
+// children returns the sorted paths of the children of znode
+func (c *fakeConn) children(znode string) []string {
+	var children []string
+	for child := range c.znodes {
+		if child != "/" && path.Dir(child) == znode {
+			children = append(children, child)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+func (c *fakeConn) stat(znode string) *zk.Stat {
+	n := c.znodes[znode]
+	return &zk.Stat{Version: n.version, EphemeralOwner: n.ephemeralOwner, NumChildren: int32(len(c.children(znode)))}
+}
+
+func (c *fakeConn) fire(znode string, eventType zk.EventType) {
+	if ch, ok := c.watches[znode]; ok {
+		delete(c.watches, znode)
+		ch <- zk.Event{Type: eventType, Path: znode}
+	}
+}
+
+func (c *fakeConn) AddAuth(scheme string, auth []byte) error {
+	return nil
+}
+
+func (c *fakeConn) Children(znode string) ([]string, *zk.Stat, error) {
+	if err := c.errs["children "+znode]; err != nil {
+		return nil, nil, err
+	}
+	if c.znodes[znode] == nil {
+		return nil, nil, zk.ErrNoNode
+	}
+	var names []string
+	for _, child := range c.children(znode) {
+		names = append(names, path.Base(child))
+	}
+	return names, c.stat(znode), nil
+}
+
+func (c *fakeConn) Delete(znode string, version int32) error {
+	if err := c.errs["delete "+znode]; err != nil {
+		return err
+	}
+	n := c.znodes[znode]
+	if n == nil {
+		return zk.ErrNoNode
+	} else if version != -1 && version != n.version {
+		return zk.ErrBadVersion
+	} else if len(c.children(znode)) != 0 {
+		return zk.ErrNotEmpty
+	}
+	delete(c.znodes, znode)
+	c.fire(znode, zk.EventNodeDeleted)
+	return nil
+}
+
+func (c *fakeConn) Exists(znode string) (bool, *zk.Stat, error) {
+	if err := c.errs["exists "+znode]; err != nil {
+		return false, nil, err
+	}
+	if c.znodes[znode] == nil {
+		return false, nil, nil
+	}
+	return true, c.stat(znode), nil
+}
+
+func (c *fakeConn) ExistsW(znode string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	exists, stat, err := c.Exists(znode)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	ch := make(chan zk.Event, 1)
+	c.watches[znode] = ch
+	return exists, stat, ch, nil
+}
+
+func (c *fakeConn) Get(znode string) ([]byte, *zk.Stat, error) {
+	if err := c.errs["get "+znode]; err != nil {
+		return nil, nil, err
+	}
+	n := c.znodes[znode]
+	if n == nil {
+		return nil, nil, zk.ErrNoNode
+	}
+	return append([]byte{}, n.data...), c.stat(znode), nil
+}
+
+// Multi applies all ops or none, the error of the first failed op is returned
+func (c *fakeConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	if err := c.errs["multi"]; err != nil {
+		return nil, err
+	}
+	saved := make(map[string]*fakeZnode)
+	for znode, n := range c.znodes {
+		saved[znode] = n
+	}
+	sequence := c.sequence
+	var resp []zk.MultiResponse
+	for _, op := range ops {
+		var err error
+		switch req := op.(type) {
+		case *zk.CreateRequest:
+			znode := req.Path
+			if req.Flags&zk.FlagSequence != 0 {
+				c.sequence++
+				znode = fmt.Sprintf("%s%010d", znode, c.sequence)
+			}
+			var owner int64
+			if req.Flags&zk.FlagEphemeral != 0 {
+				owner = c.session
+			}
+			if c.znodes[znode] != nil {
+				err = zk.ErrNodeExists
+			} else if c.znodes[path.Dir(znode)] == nil {
+				err = zk.ErrNoNode
+			} else {
+				c.znodes[znode] = &fakeZnode{data: req.Data, ephemeralOwner: owner}
+			}
+			resp = append(resp, zk.MultiResponse{String: znode})
+		case *zk.DeleteRequest:
+			err = c.Delete(req.Path, req.Version)
+			resp = append(resp, zk.MultiResponse{})
+		}
+		if err != nil {
+			c.znodes = saved
+			c.sequence = sequence
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (c *fakeConn) Set(znode string, data []byte, version int32) (*zk.Stat, error) {
+	if err := c.errs["set "+znode]; err != nil {
+		return nil, err
+	}
+	n := c.znodes[znode]
+	if n == nil {
+		return nil, zk.ErrNoNode
+	} else if version != -1 && version != n.version {
+		return nil, zk.ErrBadVersion
+	}
+	c.znodes[znode] = &fakeZnode{data: data, version: n.version + 1, ephemeralOwner: n.ephemeralOwner}
+	c.fire(znode, zk.EventNodeDataChanged)
+	return c.stat(znode), nil
+}
+
+func (c *fakeConn) SetACL(znode string, acl []zk.ACL, version int32) (*zk.Stat, error) {
+	if c.znodes[znode] == nil {
+		return nil, zk.ErrNoNode
+	}
+	return c.stat(znode), nil
+}
+
+func (c *fakeConn) SessionID() int64 {
+	return c.session
+}
+
+func (c *fakeConn) Close() {
+}